@@ -1,52 +1,294 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"itk-academy-test/config"
+	"itk-academy-test/internal/events"
 	"itk-academy-test/internal/handlers"
-	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/metrics"
+	"itk-academy-test/internal/migrations"
 	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/server"
 	"itk-academy-test/internal/services"
-	"log"
+	"itk-academy-test/internal/streaming"
+	"itk-academy-test/internal/telemetry"
+	"itk-academy-test/internal/workers"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormtracing "gorm.io/plugin/opentelemetry/tracing"
 )
 
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// openDB connects to Postgres through dsn with the pool settings of cfg.
+func openDB(cfg config.PostgresConfig, dsn string) (*gorm.DB, *sql.DB, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logging.NewGormLogger(200 * time.Millisecond),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to database: %w", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, nil, fmt.Errorf("get sql.DB: %w", err)
+	}
+
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	return db, sqlDB, nil
+}
+
+// newEventPublisher returns the publisher selected by cfg, or nil when
+// events are not published.
+func newEventPublisher(cfg config.EventsConfig) (events.EventPublisher, error) {
+	switch cfg.Publisher {
+	case "none":
+		return nil, nil
+	case "stdout":
+		return &events.StdoutPublisher{}, nil
+	case "webhook":
+		if cfg.WebhookURL == "" {
+			return nil, errors.New("EVENTS_WEBHOOK_URL is required by the webhook publisher")
+		}
+		return events.NewWebhookPublisher(cfg.WebhookURL, cfg.WebhookTimeout), nil
+	default:
+		return nil, fmt.Errorf("unknown publisher %q", cfg.Publisher)
+	}
+}
+
 func main() {
-	r := gin.Default()
+	// The level is raised or lowered once LOG_LEVEL is known.
+	logLevel := new(slog.LevelVar)
+	slog.SetDefault(logging.New(os.Stdout, logLevel))
 
 	postgresConfig := config.PostgresConfig{}
 	postgresConfig = postgresConfig.Load()
 
-	db, err := gorm.Open(postgres.Open(postgresConfig.Print()))
+	loggingConfig := config.LoggingConfig{}
+	loggingConfig = loggingConfig.Load()
+	logLevel.Set(loggingConfig.Level)
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(postgresConfig, os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		// Stdout carries the report, so the logs go to stderr.
+		slog.SetDefault(logging.New(os.Stderr, logLevel))
+		os.Exit(runReconcile(postgresConfig, os.Args[2:]))
+	}
+
+	serve(postgresConfig)
+}
+
+func serve(postgresConfig config.PostgresConfig) {
+	serverConfig := config.ServerConfig{}
+	serverConfig = serverConfig.Load()
+
+	workerConfig := config.WorkerConfig{}
+	workerConfig = workerConfig.Load()
+
+	walletConfig := config.WalletConfig{}
+	walletConfig = walletConfig.Load()
+
+	concurrency := repository.ConcurrencyMode(walletConfig.ConcurrencyMode)
+	if !concurrency.IsValid() {
+		fatal("Invalid WALLET_CONCURRENCY_MODE", fmt.Errorf("unknown mode %q", walletConfig.ConcurrencyMode))
+	}
+
+	eventsConfig := config.EventsConfig{}
+	eventsConfig = eventsConfig.Load()
+
+	publisher, err := newEventPublisher(eventsConfig)
 	if err != nil {
-		log.Fatal("Failed to connect to database: ", err)
+		fatal("Invalid EVENTS_PUBLISHER", err)
 	}
 
-	sqlDB, err := db.DB()
+	webhookConfig := config.WebhookConfig{}
+	webhookConfig = webhookConfig.Load()
+
+	streamingConfig := config.StreamingConfig{}
+	streamingConfig = streamingConfig.Load()
+
+	tracingConfig := config.TracingConfig{}
+	tracingConfig = tracingConfig.Load()
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), tracingConfig)
 	if err != nil {
-		log.Fatal("failed to get sql.DB: %w", err)
+		fatal("Failed to set up tracing", err)
 	}
 
-	sqlDB.SetMaxOpenConns(postgresConfig.MaxOpenConns)
-	sqlDB.SetMaxIdleConns(postgresConfig.MaxIdleConns)
-	sqlDB.SetConnMaxLifetime(postgresConfig.ConnMaxLifetime)
-	sqlDB.SetConnMaxIdleTime(postgresConfig.ConnMaxIdleTime)
+	r := gin.New()
+	r.Use(telemetry.Middleware(tracingConfig.ServiceName))
+	r.Use(logging.Middleware(slog.Default()))
+	r.Use(metrics.Middleware())
+	r.Use(logging.Recovery())
 
-	err = db.AutoMigrate(
-		&models.Wallet{},
-	)
+	// The balance streams hear of the changes made through sessions that
+	// announce them.
+	dsn := postgresConfig.Print()
+	if streamingConfig.Enabled {
+		dsn = streaming.NotifyingDSN(dsn)
+	}
+	db, sqlDB, err := openDB(postgresConfig, dsn)
+	if err != nil {
+		fatal("Failed to open the database", err)
+	}
+
+	if err := db.Use(gormtracing.NewPlugin(gormtracing.WithoutMetrics())); err != nil {
+		fatal("Failed to instrument the database", err)
+	}
+
+	metrics.RegisterDBStats(sqlDB)
+
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		fatal("Failed to load migrations", err)
+	}
 
+	pending, err := migrator.Pending(context.Background())
 	if err != nil {
-		log.Fatal("Failed to migrate the database", err)
+		fatal("Failed to check the schema version", err)
+	}
+	if len(pending) > 0 {
+		slog.Error("Database schema is behind, run the migrate up command first",
+			"pending", len(pending), "next_version", pending[0].Version)
+		os.Exit(1)
 	}
 
-	walletRepository := &repository.WalletGORMRepository{DB: db}
+	walletRepository := &repository.WalletGORMRepository{
+		DB:          db,
+		Concurrency: concurrency,
+		Retry: repository.RetryPolicy{
+			MaxAttempts: walletConfig.MaxAttempts,
+			BaseDelay:   walletConfig.BaseBackoff,
+			MaxDelay:    walletConfig.MaxBackoff,
+		},
+		Outbox: repository.OutboxPolicy{
+			Lease:     eventsConfig.RelayLease,
+			BaseDelay: eventsConfig.RetryBaseBackoff,
+			MaxDelay:  eventsConfig.RetryMaxBackoff,
+		},
+	}
 	walletService := services.New(walletRepository)
+	if walletConfig.CoalesceWindow > 0 {
+		walletService.EnableCoalescing(walletConfig.CoalesceWindow, walletConfig.CoalesceMaxSize)
+	}
 	walletHandler := handlers.New(walletService)
+	walletHandler.MaxBatchSize = walletConfig.MaxBatchSize
+	if streamingConfig.Enabled {
+		walletHandler.Hub = streaming.NewHub()
+	}
 
 	walletHandler.Initialize(r)
 
-	r.Run(":9090")
+	webhookService := services.NewWebhookService(
+		&repository.WebhookGORMRepository{DB: db},
+		events.NewPublicClient(webhookConfig.Timeout),
+		services.WebhookRetryPolicy{
+			MaxAttempts: webhookConfig.MaxAttempts,
+			BaseDelay:   webhookConfig.BaseBackoff,
+			MaxDelay:    webhookConfig.MaxBackoff,
+		},
+	)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	webhookHandler.Initialize(r)
+
+	srv := server.New(r, serverConfig)
+
+	healthHandler := handlers.NewHealthHandler(db, srv.Ready, func() ([]string, error) {
+		pending, err := migrator.Pending(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(pending))
+		for _, m := range pending {
+			names = append(names, fmt.Sprintf("%04d_%s", m.Version, m.Name))
+		}
+		return names, nil
+	})
+	healthHandler.Initialize(r)
+
+	r.GET("/metrics", metrics.Handler())
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+
+	holdExpiryWorker := workers.NewHoldExpiryWorker(walletService, workerConfig.HoldExpiryInterval, workerConfig.HoldExpiryBatchSize)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		holdExpiryWorker.Run(ctx)
+	}()
+
+	// Every event is offered to the webhook subscriptions, after the
+	// configured publisher has accepted it.
+	relayPublisher := events.MultiPublisher{webhookService}
+	if publisher != nil {
+		relayPublisher = events.MultiPublisher{publisher, webhookService}
+	}
+	outboxRelay := workers.NewOutboxRelay(walletService, relayPublisher, eventsConfig.RelayInterval, eventsConfig.RelayBatchSize)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		outboxRelay.Run(ctx)
+	}()
+
+	if streamingConfig.Enabled {
+		walletListener := streaming.NewListener(postgresConfig.Print(), walletHandler.Hub)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			walletListener.Run(ctx)
+			// Ends the open balance streams, which would otherwise hold up
+			// the server shutdown.
+			walletHandler.Hub.Close()
+		}()
+	}
+
+	webhookDispatcher := workers.NewWebhookDispatcher(webhookService, webhookConfig.DispatchInterval, webhookConfig.DispatchBatchSize)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		webhookDispatcher.Run(ctx)
+	}()
+
+	if err := srv.Run(ctx); err != nil {
+		slog.Error("Server stopped", "error", err)
+	}
+
+	stop()
+	slog.Info("Shutting down")
+	wg.Wait()
+
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("Failed to close database", "error", err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"itk-academy-test/config"
+	"itk-academy-test/internal/migrations"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = `usage: main migrate <command>
+
+commands:
+  up            apply every pending migration
+  down          revert the most recently applied migration
+  status        list migrations and whether they are applied
+  to <version>  migrate up or down to version (0 reverts everything)
+`
+
+// runMigrate implements the migrate subcommand and returns the process exit
+// code.
+func runMigrate(postgresConfig config.PostgresConfig, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	_, sqlDB, err := openDB(postgresConfig, postgresConfig.Print())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer sqlDB.Close()
+
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := migrate(ctx, migrator, args, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errMigrateUsage) {
+			fmt.Fprint(os.Stderr, migrateUsage)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+var errMigrateUsage = errors.New("Invalid migrate command")
+
+func migrate(ctx context.Context, migrator *migrations.Migrator, args []string, out io.Writer) error {
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := migrator.Up(ctx)
+		printMigrations(out, "Applied", applied)
+		return err
+
+	case args[0] == "down" && len(args) == 1:
+		reverted, err := migrator.Down(ctx)
+		if reverted != nil {
+			printMigrations(out, "Reverted", []migrations.Migration{*reverted})
+		} else if err == nil {
+			fmt.Fprintln(out, "Nothing to revert")
+		}
+		return err
+
+	case args[0] == "to" && len(args) == 2:
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errMigrateUsage
+		}
+		ran, err := migrator.To(ctx, version)
+		printMigrations(out, "Ran", ran)
+		return err
+
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return errMigrateUsage
+	}
+}
+
+func printMigrations(out io.Writer, verb string, ran []migrations.Migration) {
+	if len(ran) == 0 {
+		fmt.Fprintln(out, "Schema is up to date")
+		return
+	}
+	for _, m := range ran {
+		fmt.Fprintf(out, "%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"itk-academy-test/config"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/services"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const reconcileUsage = `usage: main reconcile [--format json|csv] [--fix]
+
+Compares every wallet balance with the sum of the ledger postings it is a
+projection of, checks that every journal entry balances, and writes the
+discrepancies to stdout.
+
+flags:
+  --format json|csv  output format (default json)
+  --fix              book every wallet mismatch as an adjustment entry, so
+                     that the ledger agrees with the wallet balance again
+
+exit codes:
+  0  no discrepancies, or all of them fixed
+  1  the check failed
+  2  invalid arguments
+  3  discrepancies remain
+`
+
+// exitDiscrepancies is the exit code of a reconciliation that left
+// discrepancies behind, so that a nightly job can alert on it.
+const exitDiscrepancies = 3
+
+var errReconcileUsage = errors.New("Invalid reconcile arguments")
+
+type reconcileOptions struct {
+	format string
+	fix    bool
+}
+
+// walletDiscrepancy is a wallet whose balance or held amount differs from
+// its ledger accounts. Difference is Balance - LedgerBalance.
+type walletDiscrepancy struct {
+	WalletID      uuid.UUID      `json:"walletId"`
+	Currency      enums.Currency `json:"currency"`
+	Balance       int64          `json:"balance"`
+	LedgerBalance int64          `json:"ledgerBalance"`
+	Held          int64          `json:"held"`
+	LedgerHeld    int64          `json:"ledgerHeld"`
+	Difference    int64          `json:"difference"`
+	AdjustmentID  *uuid.UUID     `json:"adjustmentId,omitempty"`
+}
+
+type entryDiscrepancy struct {
+	EntryID uuid.UUID `json:"entryId"`
+	Sum     int64     `json:"sum"`
+}
+
+type reconciliation struct {
+	CheckedAt         time.Time           `json:"checkedAt"`
+	Wallets           int64               `json:"wallets"`
+	Mismatches        []walletDiscrepancy `json:"mismatches"`
+	UnbalancedEntries []entryDiscrepancy  `json:"unbalancedEntries"`
+}
+
+// remaining counts the discrepancies that were not fixed.
+func (r *reconciliation) remaining() int {
+	n := len(r.UnbalancedEntries)
+	for _, m := range r.Mismatches {
+		if m.AdjustmentID == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// runReconcile implements the reconcile subcommand and returns the process
+// exit code.
+func runReconcile(postgresConfig config.PostgresConfig, args []string) int {
+	opts, err := parseReconcileArgs(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(os.Stdout, reconcileUsage)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprint(os.Stderr, reconcileUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, sqlDB, err := openDB(postgresConfig, postgresConfig.Print())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer sqlDB.Close()
+
+	svc := services.New(&repository.WalletGORMRepository{DB: db})
+
+	result, err := reconcile(ctx, svc, opts.fix)
+	if result != nil {
+		if writeErr := writeReconciliation(os.Stdout, opts.format, result); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if result.remaining() > 0 {
+		return exitDiscrepancies
+	}
+	return 0
+}
+
+func parseReconcileArgs(args []string) (reconcileOptions, error) {
+	opts := reconcileOptions{}
+
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&opts.format, "format", "json", "")
+	flags.BoolVar(&opts.fix, "fix", false, "")
+
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return opts, err
+	} else if err != nil || flags.NArg() > 0 {
+		return opts, errReconcileUsage
+	}
+	if opts.format != "json" && opts.format != "csv" {
+		return opts, fmt.Errorf("%w: unknown format %q", errReconcileUsage, opts.format)
+	}
+	return opts, nil
+}
+
+// reconcile runs the check and, with fix, adjusts the ledger of every
+// mismatching wallet. A failed adjustment stops the fixing; the result so
+// far is returned along with the error.
+func reconcile(ctx context.Context, svc *services.WalletService, fix bool) (*reconciliation, error) {
+	report, err := svc.Reconcile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &reconciliation{
+		CheckedAt:         time.Now().UTC(),
+		Wallets:           report.Wallets,
+		Mismatches:        make([]walletDiscrepancy, len(report.Mismatches)),
+		UnbalancedEntries: make([]entryDiscrepancy, len(report.UnbalancedEntries)),
+	}
+	for i, m := range report.Mismatches {
+		result.Mismatches[i] = walletDiscrepancy{
+			WalletID:      m.WalletID,
+			Currency:      m.Currency,
+			Balance:       m.Balance,
+			LedgerBalance: m.LedgerBalance,
+			Held:          m.Held,
+			LedgerHeld:    m.LedgerHeld,
+			Difference:    m.Balance - m.LedgerBalance,
+		}
+	}
+	for i, e := range report.UnbalancedEntries {
+		result.UnbalancedEntries[i] = entryDiscrepancy{EntryID: e.EntryID, Sum: e.Sum}
+	}
+
+	if !fix {
+		return result, nil
+	}
+
+	for i := range result.Mismatches {
+		m := &result.Mismatches[i]
+		entry, err := svc.AdjustLedger(ctx, m.WalletID)
+		if err != nil {
+			return result, fmt.Errorf("adjust wallet %s: %w", m.WalletID, err)
+		}
+		if entry != nil {
+			m.AdjustmentID = &entry.ID
+		}
+	}
+	return result, nil
+}
+
+var reconcileCSVHeader = []string{
+	"kind", "wallet_id", "entry_id", "currency",
+	"balance", "ledger_balance", "held", "ledger_held", "difference", "adjustment_id",
+}
+
+// writeReconciliation writes result as one JSON document, or as CSV with a
+// row per discrepancy: kind "wallet" for a wallet mismatch, kind "entry" for
+// an unbalanced journal entry, whose sum is given as the difference.
+func writeReconciliation(out io.Writer, format string, result *reconciliation) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	w := csv.NewWriter(out)
+	if err := w.Write(reconcileCSVHeader); err != nil {
+		return err
+	}
+	for _, m := range result.Mismatches {
+		adjustment := ""
+		if m.AdjustmentID != nil {
+			adjustment = m.AdjustmentID.String()
+		}
+		if err := w.Write([]string{
+			"wallet", m.WalletID.String(), "", string(m.Currency),
+			itoa(m.Balance), itoa(m.LedgerBalance), itoa(m.Held), itoa(m.LedgerHeld), itoa(m.Difference), adjustment,
+		}); err != nil {
+			return err
+		}
+	}
+	for _, e := range result.UnbalancedEntries {
+		if err := w.Write([]string{
+			"entry", "", e.EntryID.String(), "",
+			"", "", "", "", itoa(e.Sum), "",
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
@@ -2,7 +2,7 @@ package config
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"time"
@@ -26,10 +26,14 @@ type PostgresConfig struct {
 	ConnMaxIdleTime time.Duration
 }
 
+// Load loads config.env into the environment and reads the Postgres
+// settings from it. The other Load methods only read the environment, so
+// this one must run first.
 func (*PostgresConfig) Load() PostgresConfig {
 	err := godotenv.Load("config.env")
 	if err != nil {
-		log.Fatalln("No .env file found or failed to load. Using system env.")
+		slog.Error("No .env file found or failed to load", "error", err)
+		os.Exit(1)
 	}
 
 	return PostgresConfig{
@@ -45,6 +49,177 @@ func (*PostgresConfig) Load() PostgresConfig {
 	}
 }
 
+type ServerConfig struct {
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	DrainDelay      time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// Load reads the HTTP server settings from the environment.
+// SERVER_DRAIN_DELAY is how long the server keeps serving, reporting not
+// ready, before it stops accepting connections on shutdown.
+func (*ServerConfig) Load() ServerConfig {
+	addr := getEnv("SERVER_ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+
+	return ServerConfig{
+		Addr:            addr,
+		ReadTimeout:     time.Duration(getEnvAsIntOrDefault("SERVER_READ_TIMEOUT", 10)) * time.Second,
+		WriteTimeout:    time.Duration(getEnvAsIntOrDefault("SERVER_WRITE_TIMEOUT", 10)) * time.Second,
+		IdleTimeout:     time.Duration(getEnvAsIntOrDefault("SERVER_IDLE_TIMEOUT", 60)) * time.Second,
+		DrainDelay:      time.Duration(getEnvAsIntOrDefault("SERVER_DRAIN_DELAY", 5)) * time.Second,
+		ShutdownTimeout: time.Duration(getEnvAsIntOrDefault("SERVER_SHUTDOWN_TIMEOUT", 15)) * time.Second,
+	}
+}
+
+type WorkerConfig struct {
+	HoldExpiryInterval  time.Duration
+	HoldExpiryBatchSize int
+}
+
+// Load reads the worker settings from the environment. Non-positive values
+// fall back to the defaults.
+func (*WorkerConfig) Load() WorkerConfig {
+	return WorkerConfig{
+		HoldExpiryInterval:  time.Duration(getEnvAsPositiveIntOrDefault("HOLD_EXPIRY_INTERVAL", 30)) * time.Second,
+		HoldExpiryBatchSize: getEnvAsPositiveIntOrDefault("HOLD_EXPIRY_BATCH_SIZE", 100),
+	}
+}
+
+type WalletConfig struct {
+	ConcurrencyMode string
+	MaxAttempts     int
+	BaseBackoff     time.Duration
+	MaxBackoff      time.Duration
+	MaxBatchSize    int
+	CoalesceWindow  time.Duration
+	CoalesceMaxSize int
+}
+
+// Load reads the wallet write strategy from the environment:
+// WALLET_CONCURRENCY_MODE is "pessimistic" (row locks, the default) or
+// "optimistic" (versioned conditional updates retried up to
+// WALLET_OPTIMISTIC_MAX_ATTEMPTS times with exponential backoff).
+// WALLET_BATCH_MAX_SIZE bounds the operations of one batch request.
+// WALLET_COALESCE_WINDOW_MS, when positive, turns on per-wallet write
+// coalescing of up to WALLET_COALESCE_MAX_SIZE operations per group.
+func (*WalletConfig) Load() WalletConfig {
+	return WalletConfig{
+		ConcurrencyMode: getEnvOrDefault("WALLET_CONCURRENCY_MODE", "pessimistic"),
+		MaxAttempts:     getEnvAsIntOrDefault("WALLET_OPTIMISTIC_MAX_ATTEMPTS", 5),
+		BaseBackoff:     time.Duration(getEnvAsIntOrDefault("WALLET_OPTIMISTIC_BASE_BACKOFF_MS", 5)) * time.Millisecond,
+		MaxBackoff:      time.Duration(getEnvAsIntOrDefault("WALLET_OPTIMISTIC_MAX_BACKOFF_MS", 100)) * time.Millisecond,
+		MaxBatchSize:    getEnvAsIntOrDefault("WALLET_BATCH_MAX_SIZE", 1000),
+		CoalesceWindow:  time.Duration(getEnvAsIntOrDefault("WALLET_COALESCE_WINDOW_MS", 0)) * time.Millisecond,
+		CoalesceMaxSize: getEnvAsIntOrDefault("WALLET_COALESCE_MAX_SIZE", 100),
+	}
+}
+
+type EventsConfig struct {
+	Publisher        string
+	WebhookURL       string
+	WebhookTimeout   time.Duration
+	RelayInterval    time.Duration
+	RelayBatchSize   int
+	RelayLease       time.Duration
+	RetryBaseBackoff time.Duration
+	RetryMaxBackoff  time.Duration
+}
+
+// Load reads the domain event settings from the environment.
+// EVENTS_PUBLISHER is "none" (the default; events only go to the webhook
+// subscriptions), "stdout" or "webhook", which POSTs every event to
+// EVENTS_WEBHOOK_URL. A relay run may take EVENTS_RELAY_LEASE seconds over
+// the events it claims. An event that fails is retried with a backoff
+// doubling from EVENTS_RETRY_BASE_BACKOFF up to EVENTS_RETRY_MAX_BACKOFF
+// seconds. Non-positive relay settings fall back to the defaults.
+func (*EventsConfig) Load() EventsConfig {
+	return EventsConfig{
+		Publisher:        getEnvOrDefault("EVENTS_PUBLISHER", "none"),
+		WebhookURL:       getEnv("EVENTS_WEBHOOK_URL"),
+		WebhookTimeout:   time.Duration(getEnvAsIntOrDefault("EVENTS_WEBHOOK_TIMEOUT", 10)) * time.Second,
+		RelayInterval:    time.Duration(getEnvAsPositiveIntOrDefault("EVENTS_RELAY_INTERVAL_MS", 1000)) * time.Millisecond,
+		RelayBatchSize:   getEnvAsPositiveIntOrDefault("EVENTS_RELAY_BATCH_SIZE", 100),
+		RelayLease:       time.Duration(getEnvAsPositiveIntOrDefault("EVENTS_RELAY_LEASE", 60)) * time.Second,
+		RetryBaseBackoff: time.Duration(getEnvAsPositiveIntOrDefault("EVENTS_RETRY_BASE_BACKOFF", 1)) * time.Second,
+		RetryMaxBackoff:  time.Duration(getEnvAsPositiveIntOrDefault("EVENTS_RETRY_MAX_BACKOFF", 600)) * time.Second,
+	}
+}
+
+type WebhookConfig struct {
+	Timeout           time.Duration
+	MaxAttempts       int
+	BaseBackoff       time.Duration
+	MaxBackoff        time.Duration
+	DispatchInterval  time.Duration
+	DispatchBatchSize int
+}
+
+// Load reads the webhook delivery settings from the environment. A failed
+// delivery is retried up to WEBHOOK_MAX_ATTEMPTS times in total, with a
+// backoff doubling from WEBHOOK_BASE_BACKOFF up to WEBHOOK_MAX_BACKOFF
+// seconds, before it is dead. Non-positive timeouts, dispatch intervals and
+// batch sizes fall back to the defaults.
+func (*WebhookConfig) Load() WebhookConfig {
+	return WebhookConfig{
+		Timeout:           time.Duration(getEnvAsPositiveIntOrDefault("WEBHOOK_TIMEOUT", 10)) * time.Second,
+		MaxAttempts:       getEnvAsIntOrDefault("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseBackoff:       time.Duration(getEnvAsIntOrDefault("WEBHOOK_BASE_BACKOFF", 10)) * time.Second,
+		MaxBackoff:        time.Duration(getEnvAsIntOrDefault("WEBHOOK_MAX_BACKOFF", 3600)) * time.Second,
+		DispatchInterval:  time.Duration(getEnvAsPositiveIntOrDefault("WEBHOOK_DISPATCH_INTERVAL_MS", 1000)) * time.Millisecond,
+		DispatchBatchSize: getEnvAsPositiveIntOrDefault("WEBHOOK_DISPATCH_BATCH_SIZE", 50),
+	}
+}
+
+type StreamingConfig struct {
+	Enabled bool
+}
+
+// Load reads STREAMING_ENABLED, which turns on the live balance streams and
+// with them the announcement of every wallet change. It is off by default,
+// since the announcements serialise the commits of wallet changes.
+func (*StreamingConfig) Load() StreamingConfig {
+	enabled, err := strconv.ParseBool(getEnvOrDefault("STREAMING_ENABLED", "false"))
+	if err != nil {
+		slog.Warn("Ignoring invalid setting", "key", "STREAMING_ENABLED", "error", err)
+	}
+
+	return StreamingConfig{Enabled: enabled}
+}
+
+type LoggingConfig struct {
+	Level slog.Level
+}
+
+// Load reads the log level (debug, info, warn or error) from LOG_LEVEL,
+// defaulting to info.
+func (*LoggingConfig) Load() LoggingConfig {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getEnvOrDefault("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	return LoggingConfig{Level: level}
+}
+
+type TracingConfig struct {
+	Exporter    string
+	ServiceName string
+}
+
+// Load reads the tracing settings from the environment.
+func (*TracingConfig) Load() TracingConfig {
+	return TracingConfig{
+		Exporter:    getEnvOrDefault("TRACING_EXPORTER", "none"),
+		ServiceName: getEnvOrDefault("OTEL_SERVICE_NAME", "wallet"),
+	}
+}
+
 func getEnv(key string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -53,6 +228,13 @@ func getEnv(key string) string {
 	return ""
 }
 
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := getEnv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 func getEnvAsInt(key string) int {
 	valueStr := getEnv(key)
 	if value, err := strconv.Atoi(valueStr); err == nil {
@@ -61,6 +243,23 @@ func getEnvAsInt(key string) int {
 	return 0
 }
 
+func getEnvAsIntOrDefault(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(getEnv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsPositiveIntOrDefault is getEnvAsIntOrDefault for settings such as
+// intervals and batch sizes, for which zero or less makes no sense.
+func getEnvAsPositiveIntOrDefault(key string, defaultValue int) int {
+	if value := getEnvAsIntOrDefault(key, defaultValue); value > 0 {
+		return value
+	}
+	slog.Warn("Ignoring non-positive setting", "key", key, "default", defaultValue)
+	return defaultValue
+}
+
 func (c *PostgresConfig) Print() string {
 	return fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
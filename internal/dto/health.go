@@ -0,0 +1,30 @@
+package dto
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Status            string         `json:"status"`
+	Draining          bool           `json:"draining"`
+	Database          DatabaseStatus `json:"database"`
+	PendingMigrations []string       `json:"pendingMigrations"`
+}
+
+type DatabaseStatus struct {
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Pool   PoolStats `json:"pool"`
+}
+
+type PoolStats struct {
+	MaxOpenConnections int   `json:"maxOpenConnections"`
+	OpenConnections    int   `json:"openConnections"`
+	InUse              int   `json:"inUse"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"waitCount"`
+	WaitDurationMs     int64 `json:"waitDurationMs"`
+	MaxIdleClosed      int64 `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64 `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64 `json:"maxLifetimeClosed"`
+}
@@ -0,0 +1,30 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateHoldRequest struct {
+	Amount     int64  `json:"amount" binding:"required,gt=0"`
+	Currency   string `json:"currency,omitempty" binding:"omitempty,iso4217"`
+	TTLSeconds int64  `json:"ttlSeconds,omitempty" binding:"omitempty,gt=0"`
+}
+
+// CaptureHoldRequest captures the whole hold when Amount is omitted.
+type CaptureHoldRequest struct {
+	Amount int64 `json:"amount,omitempty" binding:"omitempty,gt=0"`
+}
+
+type HoldResponse struct {
+	ID             uuid.UUID       `json:"id"`
+	WalletID       uuid.UUID       `json:"walletId"`
+	Amount         int64           `json:"amount"`
+	CapturedAmount int64           `json:"capturedAmount"`
+	Currency       string          `json:"currency"`
+	Status         string          `json:"status"`
+	ExpiresAt      time.Time       `json:"expiresAt"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	Wallet         *WalletResponse `json:"wallet,omitempty"`
+}
@@ -0,0 +1,32 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TransactionHistoryQuery struct {
+	OperationType string     `form:"operationType" binding:"omitempty,oneof=DEPOSIT WITHDRAW TRANSFER HOLD CAPTURE RELEASE"`
+	From          *time.Time `form:"from"`
+	To            *time.Time `form:"to"`
+	Cursor        string     `form:"cursor"`
+	Limit         int        `form:"limit" binding:"omitempty,gt=0,lte=100"`
+}
+
+type TransactionResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	WalletID       uuid.UUID  `json:"walletId"`
+	OperationType  string     `json:"operationType"`
+	Amount         int64      `json:"amount"`
+	BalanceBefore  int64      `json:"balanceBefore"`
+	BalanceAfter   int64      `json:"balanceAfter"`
+	Currency       string     `json:"currency"`
+	CounterpartyID *uuid.UUID `json:"counterpartyId,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type TransactionListResponse struct {
+	Items      []TransactionResponse `json:"items"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+}
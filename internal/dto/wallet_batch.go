@@ -0,0 +1,33 @@
+package dto
+
+const (
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+)
+
+// BatchOperationRequest applies Operations in order. In atomic mode either
+// all of them succeed or none is applied; in best_effort mode each one
+// succeeds or fails on its own.
+type BatchOperationRequest struct {
+	Mode       string                   `json:"mode" binding:"required,oneof=atomic best_effort"`
+	Operations []WalletOperationRequest `json:"operations" binding:"required,min=1,dive"`
+}
+
+// BatchItemResult is the outcome of the operation at Index. Status is
+// "applied", "failed" or, for the operations of a failed atomic batch that
+// were not at fault, "rolled_back".
+type BatchItemResult struct {
+	Index    int             `json:"index"`
+	Status   string          `json:"status"`
+	Wallet   *WalletResponse `json:"wallet,omitempty"`
+	Replayed bool            `json:"replayed,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Code     string          `json:"code,omitempty"`
+}
+
+type BatchOperationResponse struct {
+	Mode      string            `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []BatchItemResult `json:"results"`
+}
@@ -0,0 +1,5 @@
+package dto
+
+type CreateWalletRequest struct {
+	Currency string `json:"currency" binding:"omitempty,iso4217"`
+}
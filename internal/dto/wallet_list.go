@@ -0,0 +1,17 @@
+package dto
+
+type WalletListQuery struct {
+	SortBy     string `form:"sortBy" binding:"omitempty,oneof=balance createdAt"`
+	Order      string `form:"order" binding:"omitempty,oneof=asc desc"`
+	MinBalance *int64 `form:"minBalance"`
+	MaxBalance *int64 `form:"maxBalance"`
+	Currency   string `form:"currency" binding:"omitempty,iso4217"`
+	Cursor     string `form:"cursor"`
+	Limit      int    `form:"limit" binding:"omitempty,gt=0,lte=100"`
+}
+
+type WalletListResponse struct {
+	Items      []WalletResponse `json:"items"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+	Total      int64            `json:"total"`
+}
@@ -4,6 +4,17 @@ import "github.com/google/uuid"
 
 type WalletOperationRequest struct {
 	WalletID      uuid.UUID `json:"valletId" binding:"required"`
-	OperationType string    `json:"operationType" binding:"required,oneof=DEPOSIT WITHDRAW"`
-	Amount        int       `json:"amount" binding:"required,gt=0"`
+	OperationType string    `json:"operationType" binding:"required,oneof=DEPOSIT WITHDRAW TRANSFER"`
+	Amount        int64     `json:"amount" binding:"required,gt=0"`
+
+	// Currency, when set, must match the wallet currency. Amount is always in
+	// minor units of that currency.
+	Currency string `json:"currency,omitempty" binding:"omitempty,iso4217"`
+
+	// ToWalletID is the credited wallet of a TRANSFER.
+	ToWalletID *uuid.UUID `json:"toWalletId,omitempty" binding:"required_if=OperationType TRANSFER"`
+
+	// IdempotencyKey may also be sent as the Idempotency-Key header, which
+	// takes precedence.
+	IdempotencyKey string `json:"idempotencyKey,omitempty" binding:"omitempty,max=255"`
 }
@@ -1,9 +1,16 @@
 package dto
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type WalletResponse struct {
-	WalletID uuid.UUID `json:"walletId"`
-	Balance  int       `json:"balance"`
-	Message  string    `json:"message,omitempty"`
+	WalletID  uuid.UUID  `json:"walletId"`
+	Balance   int64      `json:"balance"`
+	Available int64      `json:"available"`
+	Currency  string     `json:"currency,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	Message   string     `json:"message,omitempty"`
 }
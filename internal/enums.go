@@ -5,4 +5,47 @@ type OperationType string
 const (
 	DEPOSIT  OperationType = "DEPOSIT"
 	WITHDRAW OperationType = "WITHDRAW"
+	TRANSFER OperationType = "TRANSFER"
+	HOLD     OperationType = "HOLD"
+	CAPTURE  OperationType = "CAPTURE"
+	RELEASE  OperationType = "RELEASE"
 )
+
+type HoldStatus string
+
+const (
+	HoldActive   HoldStatus = "ACTIVE"
+	HoldCaptured HoldStatus = "CAPTURED"
+	HoldReleased HoldStatus = "RELEASED"
+	HoldExpired  HoldStatus = "EXPIRED"
+)
+
+// DeliveryStatus is the state of a webhook delivery. A delivery that used up
+// its attempts is DEAD and stays in the dead-letter queue until it is
+// retried by hand.
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "PENDING"
+	DeliveryDelivered DeliveryStatus = "DELIVERED"
+	DeliveryDead      DeliveryStatus = "DEAD"
+)
+
+// Currency is an ISO-4217 alphabetic currency code.
+type Currency string
+
+const (
+	EUR Currency = "EUR"
+	USD Currency = "USD"
+	RUB Currency = "RUB"
+
+	DefaultCurrency = RUB
+)
+
+func (c Currency) IsSupported() bool {
+	switch c {
+	case EUR, USD, RUB:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateDestination is returned when a webhook would connect to an
+// address that is not publicly routable.
+var ErrPrivateDestination = errors.New("destination address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which netip does not
+// count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// PublicOnly is a net.Dialer Control function that refuses to connect to
+// loopback, private, link-local (such as the 169.254.169.254 metadata
+// endpoint), multicast and unspecified addresses. It sees the address
+// actually dialled, after name resolution, so a host name that resolves to
+// an internal address is refused as well, whenever it is resolved.
+func PublicOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPrivateDestination, address)
+	}
+
+	ip := addrPort.Addr().Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateDestination, ip)
+	}
+	return nil
+}
+
+// NewPublicClient returns an HTTP client timing out after timeout that only
+// connects to public addresses. It ignores the proxy settings of the
+// environment, since a proxy would connect on its behalf unchecked.
+func NewPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   PublicOnly,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
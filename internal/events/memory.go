@@ -0,0 +1,41 @@
+package events
+
+import (
+	"context"
+	"itk-academy-test/internal/models"
+	"slices"
+	"sync"
+)
+
+// MemoryPublisher keeps the events it is given, for tests. While FailWith
+// has set an error, it rejects every event with it instead.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []models.OutboxEvent
+	err    error
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, event models.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+// Events returns the events published so far, in publishing order.
+func (p *MemoryPublisher) Events() []models.OutboxEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.events)
+}
+
+// FailWith makes Publish return err until it is called again with nil.
+func (p *MemoryPublisher) FailWith(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
@@ -0,0 +1,59 @@
+// Package events publishes the domain events that the outbox relay reads
+// from the outbox table.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"itk-academy-test/internal/models"
+	"os"
+	"sync"
+)
+
+// EventPublisher delivers one event to its consumers. Returning nil means
+// the event was accepted and will not be offered again; an error leaves it
+// in the outbox to be retried. Publishers may see an event more than once
+// and must not deduplicate it themselves; consumers do, by event ID.
+type EventPublisher interface {
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// StdoutPublisher writes every event as one JSON line to Out, or to
+// os.Stdout when Out is nil.
+type StdoutPublisher struct {
+	Out io.Writer
+
+	mu sync.Mutex
+}
+
+func (p *StdoutPublisher) Publish(_ context.Context, event models.OutboxEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	out := p.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// MultiPublisher hands every event to each of its publishers in turn. It
+// stops at the first one that fails, so that the event stays in the outbox
+// and all of them are offered it again; each must tolerate duplicates.
+type MultiPublisher []EventPublisher
+
+func (p MultiPublisher) Publish(ctx context.Context, event models.OutboxEvent) error {
+	for _, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
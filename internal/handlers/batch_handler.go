@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// batchAction is what the "batch" route parameter holds for the path
+// /wallets/operations:batch.
+const batchAction = ":batch"
+
+func (h *WalletHandler) Batch(c *gin.Context) {
+	if c.Param("batch") != batchAction {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var request dto.BatchOperationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBadRequest(c, "Invalid request body", err)
+		return
+	}
+
+	limit := h.MaxBatchSize
+	if limit <= 0 {
+		limit = DefaultMaxBatchSize
+	}
+	if len(request.Operations) > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Batch exceeds the limit of %d operations", limit),
+			"code":  CodeBatchTooLarge,
+		})
+		return
+	}
+
+	items := make([]services.BatchItem, 0, len(request.Operations))
+	for _, op := range request.Operations {
+		items = append(items, services.BatchItem{
+			WalletID:       op.WalletID,
+			OperationType:  enums.OperationType(op.OperationType),
+			Amount:         op.Amount,
+			Currency:       enums.Currency(op.Currency),
+			ToWalletID:     op.ToWalletID,
+			IdempotencyKey: op.IdempotencyKey,
+		})
+	}
+
+	results, err := h.Service.Batch(c.Request.Context(), items, request.Mode == dto.BatchModeAtomic)
+	if results == nil {
+		respondError(c, err, "Couldn't apply batch")
+		return
+	}
+
+	response := dto.BatchOperationResponse{
+		Mode:    request.Mode,
+		Results: make([]dto.BatchItemResult, 0, len(results)),
+	}
+	for i, r := range results {
+		item := dto.BatchItemResult{Index: i, Status: "applied", Replayed: r.Replayed}
+
+		switch {
+		case r.Err == nil:
+			item.Wallet = &dto.WalletResponse{
+				WalletID:  r.Wallet.ID,
+				Balance:   r.Wallet.Balance,
+				Available: r.Wallet.Available(),
+				Currency:  string(r.Wallet.Currency),
+			}
+			response.Succeeded++
+		case errors.Is(r.Err, services.ErrBatchRolledBack):
+			item.Status = "rolled_back"
+			item.Error, item.Code = r.Err.Error(), CodeBatchRolledBack
+			response.Failed++
+		default:
+			item.Status = "failed"
+			item.Error, item.Code = batchItemError(c, r.Err)
+			response.Failed++
+		}
+
+		response.Results = append(response.Results, item)
+	}
+
+	// A failed atomic batch answers with the status of the operation that
+	// made it fail; best-effort batches always succeed as a whole.
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+		if m, ok := lookupError(err); ok {
+			status = m.status
+		}
+	}
+
+	c.JSON(status, response)
+}
+
+func batchItemError(c *gin.Context, err error) (string, string) {
+	if m, ok := lookupError(err); ok {
+		return m.err.Error(), m.code
+	}
+
+	logging.FromContext(c.Request.Context()).Error("Couldn't apply batch operation", "error", err)
+	return "Couldn't apply wallet operation", CodeInternal
+}
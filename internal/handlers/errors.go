@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stable machine-readable codes returned in the "code" field of error
+// responses. Clients should branch on these instead of the message text.
+const (
+	CodeInvalidRequest       = "INVALID_REQUEST"
+	CodeWalletNotFound       = "WALLET_NOT_FOUND"
+	CodeInsufficientFunds    = "INSUFFICIENT_FUNDS"
+	CodeInvalidOperation     = "INVALID_OPERATION"
+	CodeInvalidAmount        = "INVALID_AMOUNT"
+	CodeSameWallet           = "SAME_WALLET"
+	CodeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+	CodeInvalidCursor        = "INVALID_CURSOR"
+	CodeUnsupportedCurrency  = "UNSUPPORTED_CURRENCY"
+	CodeCurrencyMismatch     = "CURRENCY_MISMATCH"
+	CodeHoldNotFound         = "HOLD_NOT_FOUND"
+	CodeHoldNotActive        = "HOLD_NOT_ACTIVE"
+	CodeHoldExpired          = "HOLD_EXPIRED"
+	CodeInvalidHoldTTL       = "INVALID_HOLD_TTL"
+	CodeCaptureExceedsHold   = "CAPTURE_EXCEEDS_HOLD"
+	CodeConcurrentUpdate     = "CONCURRENT_UPDATE"
+	CodeBatchTooLarge        = "BATCH_TOO_LARGE"
+	CodeBatchRolledBack      = "BATCH_ROLLED_BACK"
+	CodeSubscriptionNotFound = "SUBSCRIPTION_NOT_FOUND"
+	CodeInvalidEventTypes    = "INVALID_EVENT_TYPES"
+	CodeDeliveryNotFound     = "DELIVERY_NOT_FOUND"
+	CodeDeliveryNotDead      = "DELIVERY_NOT_DEAD"
+	CodeStreamingUnavailable = "STREAMING_UNAVAILABLE"
+	CodeInternal             = "INTERNAL_ERROR"
+)
+
+type errorMapping struct {
+	err    error
+	status int
+	code   string
+}
+
+var errorMappings = []errorMapping{
+	{services.ErrWalletNotFound, http.StatusNotFound, CodeWalletNotFound},
+	{services.ErrInsufficientFunds, http.StatusUnprocessableEntity, CodeInsufficientFunds},
+	{services.ErrInvalidOperation, http.StatusBadRequest, CodeInvalidOperation},
+	{services.ErrInvalidAmount, http.StatusBadRequest, CodeInvalidAmount},
+	{services.ErrSameWallet, http.StatusBadRequest, CodeSameWallet},
+	{services.ErrIdempotencyKeyReused, http.StatusConflict, CodeIdempotencyKeyReused},
+	{services.ErrInvalidCursor, http.StatusBadRequest, CodeInvalidCursor},
+	{services.ErrUnsupportedCurrency, http.StatusBadRequest, CodeUnsupportedCurrency},
+	{services.ErrCurrencyMismatch, http.StatusUnprocessableEntity, CodeCurrencyMismatch},
+	{services.ErrHoldNotFound, http.StatusNotFound, CodeHoldNotFound},
+	{services.ErrHoldNotActive, http.StatusConflict, CodeHoldNotActive},
+	{services.ErrHoldExpired, http.StatusConflict, CodeHoldExpired},
+	{services.ErrInvalidHoldTTL, http.StatusBadRequest, CodeInvalidHoldTTL},
+	{services.ErrCaptureExceedsHold, http.StatusUnprocessableEntity, CodeCaptureExceedsHold},
+	{services.ErrConcurrentUpdate, http.StatusConflict, CodeConcurrentUpdate},
+	{services.ErrSubscriptionNotFound, http.StatusNotFound, CodeSubscriptionNotFound},
+	{services.ErrInvalidEventTypes, http.StatusBadRequest, CodeInvalidEventTypes},
+	{services.ErrDeliveryNotFound, http.StatusNotFound, CodeDeliveryNotFound},
+	{services.ErrDeliveryNotDead, http.StatusConflict, CodeDeliveryNotDead},
+}
+
+func lookupError(err error) (errorMapping, bool) {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return m, true
+		}
+	}
+	return errorMapping{}, false
+}
+
+// respondError writes err as a JSON error response. Domain errors from the
+// services package get their own status and code; anything else is reported
+// as a 500 with message as the error text.
+func respondError(c *gin.Context, err error, message string) {
+	if m, ok := lookupError(err); ok {
+		c.JSON(m.status, gin.H{"error": m.err.Error(), "code": m.code})
+		return
+	}
+
+	logging.FromContext(c.Request.Context()).Error(message, "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message, "code": CodeInternal, "detail": err.Error()})
+}
+
+func respondBadRequest(c *gin.Context, message string, err error) {
+	body := gin.H{"error": message, "code": CodeInvalidRequest}
+	if err != nil {
+		body["detail"] = err.Error()
+	}
+
+	c.JSON(http.StatusBadRequest, body)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"itk-academy-test/internal/dto"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+
+	pingTimeout = 2 * time.Second
+)
+
+type HealthHandler struct {
+	DB *gorm.DB
+
+	// Ready reports whether the HTTP server accepts traffic; it turns false
+	// while the server drains on shutdown.
+	Ready func() bool
+
+	// PendingMigrations lists the schema changes not yet applied to DB.
+	PendingMigrations func() ([]string, error)
+}
+
+func NewHealthHandler(db *gorm.DB, ready func() bool, pendingMigrations func() ([]string, error)) *HealthHandler {
+	return &HealthHandler{DB: db, Ready: ready, PendingMigrations: pendingMigrations}
+}
+
+func (h *HealthHandler) Initialize(ginEngine *gin.Engine) {
+	ginEngine.GET("/healthz", h.Liveness)
+	ginEngine.GET("/readyz", h.Readiness)
+}
+
+// Liveness only reports that the process serves HTTP; it deliberately does
+// not depend on the database so that a DB outage doesn't restart the pod.
+func (h *HealthHandler) Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, dto.HealthResponse{Status: statusOK})
+}
+
+func (h *HealthHandler) Readiness(c *gin.Context) {
+	response := dto.ReadinessResponse{
+		Status:            statusOK,
+		Draining:          h.Ready != nil && !h.Ready(),
+		Database:          h.database(c.Request.Context()),
+		PendingMigrations: []string{},
+	}
+
+	if h.PendingMigrations != nil && response.Database.Status == statusOK {
+		pending, err := h.PendingMigrations()
+		if err != nil {
+			response.Database.Status = statusUnavailable
+			response.Database.Error = err.Error()
+		} else if pending != nil {
+			response.PendingMigrations = pending
+		}
+	}
+
+	status := http.StatusOK
+	if response.Draining || response.Database.Status != statusOK || len(response.PendingMigrations) > 0 {
+		response.Status = statusUnavailable
+		status = http.StatusServiceUnavailable
+	}
+
+	c.JSON(status, response)
+}
+
+func (h *HealthHandler) database(ctx context.Context) dto.DatabaseStatus {
+	sqlDB, err := h.DB.DB()
+	if err != nil {
+		return dto.DatabaseStatus{Status: statusUnavailable, Error: err.Error()}
+	}
+
+	stats := sqlDB.Stats()
+	result := dto.DatabaseStatus{
+		Status: statusOK,
+		Pool: dto.PoolStats{
+			MaxOpenConnections: stats.MaxOpenConnections,
+			OpenConnections:    stats.OpenConnections,
+			InUse:              stats.InUse,
+			Idle:               stats.Idle,
+			WaitCount:          stats.WaitCount,
+			WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+			MaxIdleClosed:      stats.MaxIdleClosed,
+			MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		result.Status = statusUnavailable
+		result.Error = err.Error()
+	}
+
+	return result
+}
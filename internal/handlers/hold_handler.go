@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func (h *WalletHandler) CreateHold(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid wallet ID", err)
+		return
+	}
+
+	var request dto.CreateHoldRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBadRequest(c, "Invalid request body", err)
+		return
+	}
+
+	ttl := time.Duration(request.TTLSeconds) * time.Second
+	hold, wallet, err := h.Service.CreateHold(c.Request.Context(), walletId, request.Amount, enums.Currency(request.Currency), ttl)
+	if err != nil {
+		respondError(c, err, "Couldn't create hold")
+		return
+	}
+
+	c.JSON(http.StatusCreated, holdResponse(hold, wallet))
+}
+
+func (h *WalletHandler) GetHold(c *gin.Context) {
+	holdId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid hold ID", err)
+		return
+	}
+
+	hold, err := h.Service.GetHold(c.Request.Context(), holdId)
+	if err != nil {
+		respondError(c, err, "Couldn't get hold")
+		return
+	}
+
+	c.JSON(http.StatusOK, holdResponse(hold, nil))
+}
+
+func (h *WalletHandler) CaptureHold(c *gin.Context) {
+	holdId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid hold ID", err)
+		return
+	}
+
+	var request dto.CaptureHoldRequest
+	if c.Request.Body != nil && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, "Invalid request body", err)
+			return
+		}
+	}
+
+	hold, wallet, err := h.Service.CaptureHold(c.Request.Context(), holdId, request.Amount)
+	if err != nil {
+		respondError(c, err, "Couldn't capture hold")
+		return
+	}
+
+	c.JSON(http.StatusOK, holdResponse(hold, wallet))
+}
+
+func (h *WalletHandler) ReleaseHold(c *gin.Context) {
+	holdId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid hold ID", err)
+		return
+	}
+
+	hold, wallet, err := h.Service.ReleaseHold(c.Request.Context(), holdId)
+	if err != nil {
+		respondError(c, err, "Couldn't release hold")
+		return
+	}
+
+	c.JSON(http.StatusOK, holdResponse(hold, wallet))
+}
+
+func holdResponse(hold *models.Hold, wallet *models.Wallet) dto.HoldResponse {
+	response := dto.HoldResponse{
+		ID:             hold.ID,
+		WalletID:       hold.WalletID,
+		Amount:         hold.Amount,
+		CapturedAmount: hold.CapturedAmount,
+		Currency:       string(hold.Currency),
+		Status:         string(hold.Status),
+		ExpiresAt:      hold.ExpiresAt,
+		CreatedAt:      hold.CreatedAt,
+	}
+
+	if wallet != nil {
+		response.Wallet = &dto.WalletResponse{
+			WalletID:  wallet.ID,
+			Balance:   wallet.Balance,
+			Available: wallet.Available(),
+			Currency:  string(wallet.Currency),
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/services"
+	"itk-academy-test/internal/streaming"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// streamKeepAlive is how often an idle stream sends a comment line, so that
+// proxies do not close it.
+const streamKeepAlive = 15 * time.Second
+
+// Stream sends the wallet as a "balance" event right away and again after
+// every committed change of its balance or held amount, made through any
+// backend instance. When the wallet is deleted the stream sends a "deleted"
+// event and ends. Events carry no ID to resume from: a client that
+// reconnects gets the current state first.
+func (h *WalletHandler) Stream(c *gin.Context) {
+	walletId, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid wallet ID", err)
+		return
+	}
+
+	if h.Hub == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Streaming is not enabled", "code": CodeStreamingUnavailable})
+		return
+	}
+
+	// Subscribing before the wallet is read means that no change committed
+	// after the read can be missed; changes the read already includes are
+	// skipped by their version.
+	sub := h.Hub.Subscribe(walletId)
+	defer sub.Close()
+
+	wallet, err := h.Service.Get(c.Request.Context(), walletId)
+	if err != nil {
+		respondError(c, err, "Couldn't get wallet")
+		return
+	}
+
+	// The stream outlives the server write timeout.
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	version := wallet.Version
+	c.SSEvent("balance", walletBalance(wallet))
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+
+		case change, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+
+			switch {
+			case change.Resync:
+				wallet, err := h.Service.Get(c.Request.Context(), walletId)
+				if errors.Is(err, services.ErrWalletNotFound) {
+					c.SSEvent("deleted", gin.H{"walletId": walletId})
+					return false
+				}
+				if err != nil {
+					// The client reconnects and starts from the current state.
+					logging.FromContext(c.Request.Context()).Error("Couldn't resync wallet stream", "error", err)
+					return false
+				}
+				change = walletChange(wallet)
+			case change.Deleted:
+				c.SSEvent("deleted", gin.H{"walletId": walletId})
+				return false
+			}
+
+			if change.Version <= version {
+				return true
+			}
+			version = change.Version
+			c.SSEvent("balance", dto.WalletResponse{
+				WalletID:  change.WalletID,
+				Balance:   change.Balance,
+				Available: change.Balance - change.Held,
+				Currency:  string(change.Currency),
+			})
+			return true
+		}
+	})
+}
+
+func walletBalance(wallet *models.Wallet) dto.WalletResponse {
+	return dto.WalletResponse{
+		WalletID:  wallet.ID,
+		Balance:   wallet.Balance,
+		Available: wallet.Available(),
+		Currency:  string(wallet.Currency),
+	}
+}
+
+func walletChange(wallet *models.Wallet) streaming.WalletChange {
+	return streaming.WalletChange{
+		WalletID: wallet.ID,
+		Balance:  wallet.Balance,
+		Held:     wallet.Held,
+		Currency: wallet.Currency,
+		Version:  wallet.Version,
+	}
+}
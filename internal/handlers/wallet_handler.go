@@ -5,18 +5,32 @@ import (
 	"itk-academy-test/internal/dto"
 	"itk-academy-test/internal/models"
 	"itk-academy-test/internal/services"
+	"itk-academy-test/internal/streaming"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
 type Handler interface {
 	Iniitalize(ginEngine *gin.Engine)
 }
 
+// DefaultMaxBatchSize bounds the operations of a batch request when
+// WalletHandler.MaxBatchSize is not set.
+const DefaultMaxBatchSize = 1000
+
+// WalletHandler serves the wallet API. Hub, when set, feeds the balance
+// streams; without it they answer 503.
 type WalletHandler struct {
-	Service *services.WalletService
+	Service      *services.WalletService
+	MaxBatchSize int
+	Hub          *streaming.Hub
 }
 
 func New(s *services.WalletService) *WalletHandler {
@@ -28,22 +42,47 @@ func (h *WalletHandler) Initialize(ginEngine *gin.Engine) {
 	{
 		v1.POST("/wallets/", h.Create)
 		v1.POST("/wallet/", h.Operation)
-		v1.GET("/wallets/", h.AllWallets)
+		v1.GET("/wallets/", h.List)
+		// Gin cannot escape ':' in a route, so this registers a "batch"
+		// parameter after the static "operations" prefix; Batch rejects
+		// every path but the literal one.
+		v1.POST("/wallets/operations:batch", h.Batch)
 		v1.GET("/wallets/:id", h.Amount)
+		v1.GET("/wallets/:id/transactions", h.Transactions)
+		v1.GET("/wallets/:id/stream", h.Stream)
 		v1.DELETE("/wallets/:id", h.Delete)
+
+		v1.POST("/wallets/:id/holds", h.CreateHold)
+		v1.GET("/holds/:id", h.GetHold)
+		v1.POST("/holds/:id/capture", h.CaptureHold)
+		v1.POST("/holds/:id/release", h.ReleaseHold)
 	}
 }
 
 func (h *WalletHandler) Create(c *gin.Context) {
-	wallet, err := h.Service.Create()
+	var request dto.CreateWalletRequest
+
+	// The body is optional: a wallet created without one uses the default currency.
+	if c.Request.Body != nil && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			respondBadRequest(c, "Invalid request body", err)
+			return
+		}
+	}
+
+	wallet, err := h.Service.Create(c.Request.Context(), enums.Currency(request.Currency))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Couldn't create wallet", "detail": err.Error()})
+		respondError(c, err, "Couldn't create wallet")
+		return
 	}
 
 	response := dto.WalletResponse{
-		WalletID: wallet.ID,
-		Balance:  wallet.Balance,
-		Message:  "Wallet created",
+		WalletID:  wallet.ID,
+		Balance:   wallet.Balance,
+		Available: wallet.Available(),
+		Currency:  string(wallet.Currency),
+		CreatedAt: &wallet.CreatedAt,
+		Message:   "Wallet created",
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -53,20 +92,23 @@ func (h *WalletHandler) Amount(c *gin.Context) {
 	id := c.Param("id")
 	walletId, err := uuid.Parse(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wallet ID", "detail": err.Error()})
+		respondBadRequest(c, "Invalid wallet ID", err)
 		return
 	}
 
-	amount, err := h.Service.Amount(walletId)
+	wallet, err := h.Service.Get(c.Request.Context(), walletId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "There is error with gettint wallet amount", "detail": err.Error()})
+		respondError(c, err, "There is error with gettint wallet amount")
 		return
 	}
 
 	response := dto.WalletResponse{
-		WalletID: walletId,
-		Balance:  amount,
-		Message:  "",
+		WalletID:  walletId,
+		Balance:   wallet.Balance,
+		Available: wallet.Available(),
+		Currency:  string(wallet.Currency),
+		CreatedAt: &wallet.CreatedAt,
+		Message:   "",
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -77,13 +119,13 @@ func (h *WalletHandler) Delete(c *gin.Context) {
 	id := c.Param("id")
 	walletId, err := uuid.Parse(id)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wallet ID", "detail": err.Error()})
+		respondBadRequest(c, "Invalid wallet ID", err)
 		return
 	}
 
-	err = h.Service.Delete(walletId)
+	err = h.Service.Delete(c.Request.Context(), walletId)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "There is error with deleting wallet", "detail": err.Error()})
+		respondError(c, err, "There is error with deleting wallet")
 		return
 	}
 
@@ -95,33 +137,116 @@ func (h *WalletHandler) Operation(c *gin.Context) {
 
 	err := c.ShouldBindJSON(&request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBadRequest(c, "Invalid request body", err)
+		return
+	}
+
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		key = request.IdempotencyKey
+	}
+	if len(key) > 255 {
+		respondBadRequest(c, "Idempotency key is too long", nil)
 		return
 	}
 
+	currency := enums.Currency(request.Currency)
+
 	wallet := &models.Wallet{}
-	wallet, err = h.Service.Operation(request.WalletID, enums.OperationType(request.OperationType), request.Amount)
+	replayed := false
+	if enums.OperationType(request.OperationType) == enums.TRANSFER {
+		wallet, replayed, err = h.Service.TransferWithKey(c.Request.Context(), key, request.WalletID, *request.ToWalletID, request.Amount, currency)
+	} else {
+		wallet, replayed, err = h.Service.OperationWithKey(c.Request.Context(), key, request.WalletID, enums.OperationType(request.OperationType), request.Amount, currency)
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err, "Couldn't apply wallet operation")
 		return
 	}
 
+	if replayed {
+		c.Header(IdempotentReplayedHeader, "true")
+	}
+
 	response := dto.WalletResponse{
-		WalletID: wallet.ID,
-		Balance:  wallet.Balance,
-		Message:  "Operation completed successfully",
+		WalletID:  wallet.ID,
+		Balance:   wallet.Balance,
+		Available: wallet.Available(),
+		Currency:  string(wallet.Currency),
+		Message:   "Operation completed successfully",
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// JUST FOR TESTING
-func (h *WalletHandler) AllWallets(c *gin.Context) {
-	posts, err := h.Service.AllWallets()
+func (h *WalletHandler) Transactions(c *gin.Context) {
+	id := c.Param("id")
+	walletId, err := uuid.Parse(id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get wallets"})
+		respondBadRequest(c, "Invalid wallet ID", err)
 		return
 	}
 
-	c.JSON(http.StatusOK, posts)
+	var query dto.TransactionHistoryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBadRequest(c, "Invalid query", err)
+		return
+	}
+
+	transactions, nextCursor, err := h.Service.Transactions(c.Request.Context(), walletId, query)
+	if err != nil {
+		respondError(c, err, "Could not get wallet transactions")
+		return
+	}
+
+	response := dto.TransactionListResponse{
+		Items:      make([]dto.TransactionResponse, 0, len(transactions)),
+		NextCursor: nextCursor,
+	}
+	for _, t := range transactions {
+		response.Items = append(response.Items, dto.TransactionResponse{
+			ID:             t.ID,
+			WalletID:       t.WalletID,
+			OperationType:  string(t.OperationType),
+			Amount:         t.Amount,
+			BalanceBefore:  t.BalanceBefore,
+			BalanceAfter:   t.BalanceAfter,
+			Currency:       string(t.Currency),
+			CounterpartyID: t.CounterpartyID,
+			CreatedAt:      t.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *WalletHandler) List(c *gin.Context) {
+	var query dto.WalletListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBadRequest(c, "Invalid query", err)
+		return
+	}
+
+	wallets, nextCursor, total, err := h.Service.List(c.Request.Context(), query)
+	if err != nil {
+		respondError(c, err, "Could not get wallets")
+		return
+	}
+
+	response := dto.WalletListResponse{
+		Items:      make([]dto.WalletResponse, 0, len(wallets)),
+		NextCursor: nextCursor,
+		Total:      total,
+	}
+	for _, w := range wallets {
+		response.Items = append(response.Items, dto.WalletResponse{
+			WalletID:  w.ID,
+			Balance:   w.Balance,
+			Available: w.Available(),
+			Currency:  string(w.Currency),
+			CreatedAt: &w.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
 }
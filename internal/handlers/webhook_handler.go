@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type WebhookHandler struct {
+	Service *services.WebhookService
+}
+
+func NewWebhookHandler(s *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{Service: s}
+}
+
+func (h *WebhookHandler) Initialize(ginEngine *gin.Engine) {
+	v1 := ginEngine.Group("/api/v1")
+	{
+		v1.POST("/webhooks", h.Create)
+		v1.GET("/webhooks", h.List)
+		v1.GET("/webhooks/:id", h.Get)
+		v1.PUT("/webhooks/:id", h.Update)
+		v1.DELETE("/webhooks/:id", h.Delete)
+
+		v1.GET("/webhooks/:id/deliveries", h.Deliveries)
+		v1.POST("/webhooks/:id/deliveries/:deliveryId/retry", h.RetryDelivery)
+	}
+}
+
+// Create registers a subscription. Its response is the only one that
+// carries the secret the deliveries are signed with.
+func (h *WebhookHandler) Create(c *gin.Context) {
+	var request dto.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBadRequest(c, "Invalid request body", err)
+		return
+	}
+
+	sub, err := h.Service.CreateSubscription(c.Request.Context(), request.URL, request.EventTypes)
+	if err != nil {
+		respondError(c, err, "Couldn't create webhook")
+		return
+	}
+
+	response := webhookResponse(sub)
+	response.Secret = sub.Secret
+	c.JSON(http.StatusCreated, response)
+}
+
+func (h *WebhookHandler) List(c *gin.Context) {
+	subs, err := h.Service.ListSubscriptions(c.Request.Context())
+	if err != nil {
+		respondError(c, err, "Couldn't list webhooks")
+		return
+	}
+
+	response := dto.WebhookListResponse{Items: make([]dto.WebhookResponse, 0, len(subs))}
+	for i := range subs {
+		response.Items = append(response.Items, webhookResponse(&subs[i]))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *WebhookHandler) Get(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid webhook ID", err)
+		return
+	}
+
+	sub, err := h.Service.GetSubscription(c.Request.Context(), id)
+	if err != nil {
+		respondError(c, err, "Couldn't get webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookResponse(sub))
+}
+
+func (h *WebhookHandler) Update(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid webhook ID", err)
+		return
+	}
+
+	var request dto.UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBadRequest(c, "Invalid request body", err)
+		return
+	}
+
+	active := request.Active == nil || *request.Active
+	sub, err := h.Service.UpdateSubscription(c.Request.Context(), id, request.URL, request.EventTypes, active)
+	if err != nil {
+		respondError(c, err, "Couldn't update webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookResponse(sub))
+}
+
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid webhook ID", err)
+		return
+	}
+
+	if err := h.Service.DeleteSubscription(c.Request.Context(), id); err != nil {
+		respondError(c, err, "Couldn't delete webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// Deliveries returns the delivery log of a subscription, newest first. The
+// DEAD deliveries are its dead-letter queue.
+func (h *WebhookHandler) Deliveries(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid webhook ID", err)
+		return
+	}
+
+	var query dto.WebhookDeliveriesQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		respondBadRequest(c, "Invalid query", err)
+		return
+	}
+
+	deliveries, err := h.Service.Deliveries(c.Request.Context(), id, enums.DeliveryStatus(query.Status), query.Limit)
+	if err != nil {
+		respondError(c, err, "Couldn't get webhook deliveries")
+		return
+	}
+
+	response := dto.WebhookDeliveryListResponse{Items: make([]dto.WebhookDeliveryResponse, 0, len(deliveries))}
+	for i := range deliveries {
+		response.Items = append(response.Items, deliveryResponse(&deliveries[i]))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *WebhookHandler) RetryDelivery(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondBadRequest(c, "Invalid webhook ID", err)
+		return
+	}
+	deliveryId, err := uuid.Parse(c.Param("deliveryId"))
+	if err != nil {
+		respondBadRequest(c, "Invalid delivery ID", err)
+		return
+	}
+
+	delivery, err := h.Service.RetryDelivery(c.Request.Context(), id, deliveryId)
+	if err != nil {
+		respondError(c, err, "Couldn't retry webhook delivery")
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveryResponse(delivery))
+}
+
+func webhookResponse(sub *models.WebhookSubscription) dto.WebhookResponse {
+	return dto.WebhookResponse{
+		ID:         sub.ID,
+		URL:        sub.URL,
+		EventTypes: sub.EventTypes,
+		Active:     sub.Active,
+		CreatedAt:  sub.CreatedAt,
+		UpdatedAt:  sub.UpdatedAt,
+	}
+}
+
+func deliveryResponse(delivery *models.WebhookDelivery) dto.WebhookDeliveryResponse {
+	response := dto.WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         string(delivery.Status),
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		DeliveredAt:    delivery.DeliveredAt,
+		CreatedAt:      delivery.CreatedAt,
+	}
+
+	// The next attempt time of a delivered or dead delivery is meaningless.
+	if delivery.Status == enums.DeliveryPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+
+	return response
+}
@@ -0,0 +1,12 @@
+-- IF NOT EXISTS lets databases created by the former GORM AutoMigrate adopt
+-- the versioned migrations without changes.
+CREATE TABLE IF NOT EXISTS wallets (
+    id         uuid PRIMARY KEY,
+    balance    bigint      NOT NULL DEFAULT 0,
+    held       bigint      NOT NULL DEFAULT 0,
+    currency   char(3)     NOT NULL DEFAULT 'RUB',
+    created_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallets_balance_id ON wallets (balance, id);
+CREATE INDEX IF NOT EXISTS idx_wallets_created_at_id ON wallets (created_at, id);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS transactions (
+    id              uuid PRIMARY KEY,
+    wallet_id       uuid        NOT NULL,
+    operation_type  varchar(32) NOT NULL,
+    amount          bigint      NOT NULL,
+    balance_before  bigint      NOT NULL,
+    balance_after   bigint      NOT NULL,
+    currency        char(3)     NOT NULL DEFAULT 'RUB',
+    idempotency_key varchar(255),
+    counterparty_id uuid,
+    hold_id         uuid,
+    held_after      bigint      NOT NULL DEFAULT 0,
+    created_at      timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_wallet_id ON transactions (wallet_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions (created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_idempotency_key ON transactions (idempotency_key);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS holds (
+    id              uuid PRIMARY KEY,
+    wallet_id       uuid        NOT NULL,
+    amount          bigint      NOT NULL,
+    captured_amount bigint      NOT NULL DEFAULT 0,
+    currency        char(3)     NOT NULL,
+    status          varchar(16) NOT NULL,
+    expires_at      timestamptz NOT NULL,
+    created_at      timestamptz NOT NULL,
+    updated_at      timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_holds_wallet_id ON holds (wallet_id);
+CREATE INDEX IF NOT EXISTS idx_holds_status_expires_at ON holds (status, expires_at);
@@ -0,0 +1,3 @@
+ALTER TABLE wallets
+    DROP CONSTRAINT IF EXISTS wallets_held_within_balance,
+    DROP CONSTRAINT IF EXISTS wallets_balance_non_negative;
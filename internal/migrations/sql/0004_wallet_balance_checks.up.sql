@@ -0,0 +1,5 @@
+-- Fails if a wallet already breaks an invariant; such wallets must be fixed
+-- by hand before the migration can be applied.
+ALTER TABLE wallets
+    ADD CONSTRAINT wallets_balance_non_negative CHECK (balance >= 0),
+    ADD CONSTRAINT wallets_held_within_balance CHECK (held >= 0 AND held <= balance);
@@ -0,0 +1,79 @@
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    code       varchar(64) PRIMARY KEY,
+    kind       varchar(16) NOT NULL,
+    wallet_id  uuid,
+    currency   char(3)     NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_accounts_wallet_id ON ledger_accounts (wallet_id);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id             uuid PRIMARY KEY,
+    kind           varchar(32) NOT NULL,
+    transaction_id uuid,
+    created_at     timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_journal_entries_transaction_id ON journal_entries (transaction_id);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_created_at ON journal_entries (created_at);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id           bigserial PRIMARY KEY,
+    entry_id     uuid        NOT NULL REFERENCES journal_entries (id),
+    account_code varchar(64) NOT NULL REFERENCES ledger_accounts (code),
+    amount       bigint      NOT NULL CONSTRAINT postings_amount_non_zero CHECK (amount <> 0),
+    created_at   timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_postings_entry_id ON postings (entry_id);
+CREATE INDEX IF NOT EXISTS idx_postings_account_code ON postings (account_code);
+
+-- The postings of a journal entry must sum to zero. The check is deferred to
+-- the commit, so that an entry can be written one posting at a time.
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT sum(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % does not balance', NEW.entry_id
+            USING ERRCODE = 'check_violation', CONSTRAINT = 'postings_balanced';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS postings_balanced ON postings;
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT OR UPDATE ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_journal_entry_balanced();
+
+INSERT INTO ledger_accounts (code, kind, currency)
+VALUES ('external:EUR', 'external', 'EUR'),
+       ('external:USD', 'external', 'USD'),
+       ('external:RUB', 'external', 'RUB')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO ledger_accounts (code, kind, wallet_id, currency)
+SELECT kind || ':' || id, kind, id, currency
+FROM wallets CROSS JOIN (VALUES ('wallet'), ('hold')) AS kinds (kind)
+ON CONFLICT (code) DO NOTHING;
+
+-- Balances that predate the ledger are carried over as one opening entry per
+-- wallet, funded from the external account of its currency.
+DO $$
+DECLARE
+    w        record;
+    opening  uuid;
+BEGIN
+    FOR w IN SELECT id, balance, held, currency FROM wallets WHERE balance <> 0 LOOP
+        opening := md5('opening_balance:' || w.id)::uuid;
+        INSERT INTO journal_entries (id, kind, created_at) VALUES (opening, 'opening_balance', now());
+        INSERT INTO postings (entry_id, account_code, amount, created_at)
+        SELECT opening, code, amount, now()
+        FROM (VALUES ('wallet:' || w.id, w.balance - w.held),
+                     ('hold:' || w.id, w.held),
+                     ('external:' || w.currency, -w.balance)) AS p (code, amount)
+        WHERE amount <> 0;
+    END LOOP;
+END;
+$$;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id              uuid PRIMARY KEY,
+    seq             bigserial   NOT NULL,
+    type            varchar(64) NOT NULL,
+    wallet_id       uuid        NOT NULL,
+    payload         jsonb       NOT NULL,
+    created_at      timestamptz NOT NULL,
+    published_at    timestamptz,
+    attempts        integer     NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_error      text
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_wallet_id ON outbox_events (wallet_id);
+-- The relay only ever claims unpublished events, in the order they were
+-- written.
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events (seq) WHERE published_at IS NULL;
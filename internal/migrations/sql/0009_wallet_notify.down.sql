@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS wallets_notify_delete ON wallets;
+DROP TRIGGER IF EXISTS wallets_notify_change ON wallets;
+DROP FUNCTION IF EXISTS notify_wallet_change();
@@ -0,0 +1,47 @@
+-- Every committed change of a wallet's balance or held amount, and every
+-- deletion, is announced on the wallet_changes channel. NOTIFY is
+-- transactional: listeners hear of a change only once it commits, and never
+-- of one that was rolled back.
+--
+-- A transaction that notifies takes a database-wide lock at commit, so
+-- announcing every change would serialise the commits of all wallet
+-- writes. The triggers therefore only fire in sessions that turn the
+-- wallet.notify_changes setting on, which the backend does when
+-- STREAMING_ENABLED is set.
+CREATE OR REPLACE FUNCTION notify_wallet_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('wallet_changes', json_build_object(
+            'walletId', OLD.id,
+            'currency', OLD.currency,
+            'version', OLD.version,
+            'deleted', true
+        )::text);
+        RETURN OLD;
+    END IF;
+
+    PERFORM pg_notify('wallet_changes', json_build_object(
+        'walletId', NEW.id,
+        'balance', NEW.balance,
+        'held', NEW.held,
+        'currency', NEW.currency,
+        'version', NEW.version
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS wallets_notify_change ON wallets;
+CREATE TRIGGER wallets_notify_change
+    AFTER UPDATE OF balance, held ON wallets
+    FOR EACH ROW
+    WHEN (current_setting('wallet.notify_changes', true) = 'on'
+          AND (OLD.balance IS DISTINCT FROM NEW.balance OR OLD.held IS DISTINCT FROM NEW.held))
+    EXECUTE FUNCTION notify_wallet_change();
+
+DROP TRIGGER IF EXISTS wallets_notify_delete ON wallets;
+CREATE TRIGGER wallets_notify_delete
+    AFTER DELETE ON wallets
+    FOR EACH ROW
+    WHEN (current_setting('wallet.notify_changes', true) = 'on')
+    EXECUTE FUNCTION notify_wallet_change();
@@ -0,0 +1,22 @@
+package models
+
+import (
+	enums "itk-academy-test/internal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Hold reserves Amount of a wallet balance until it is captured, released or
+// expires. A capture may take less than Amount; the rest is released.
+type Hold struct {
+	ID             uuid.UUID        `gorm:"type:uuid;primaryKey" json:"id"`
+	WalletID       uuid.UUID        `gorm:"type:uuid;not null;index" json:"walletId"`
+	Amount         int64            `gorm:"not null" json:"amount"`
+	CapturedAmount int64            `gorm:"not null;default:0" json:"capturedAmount"`
+	Currency       enums.Currency   `gorm:"type:char(3);not null" json:"currency"`
+	Status         enums.HoldStatus `gorm:"type:varchar(16);not null;index:idx_holds_status_expires_at,priority:1" json:"status"`
+	ExpiresAt      time.Time        `gorm:"not null;index:idx_holds_status_expires_at,priority:2" json:"expiresAt"`
+	CreatedAt      time.Time        `gorm:"not null" json:"createdAt"`
+	UpdatedAt      time.Time        `gorm:"not null" json:"updatedAt"`
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	enums "itk-academy-test/internal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ledger account kinds. Every wallet owns a wallet account, holding its
+// available funds, and a hold account, holding the funds reserved by its
+// active holds. The external account of a currency stands for the world
+// outside the system: deposits come from it and withdrawals and captures go
+// to it. The adjustment account of a currency takes the other side of the
+// corrections made by reconciliation.
+const (
+	AccountWallet     = "wallet"
+	AccountHold       = "hold"
+	AccountExternal   = "external"
+	AccountAdjustment = "adjustment"
+)
+
+// Journal entry kinds.
+const (
+	// EntryOperation is posted by a wallet operation.
+	EntryOperation = "operation"
+	// EntryOpeningBalance carries a wallet balance that predates the ledger.
+	EntryOpeningBalance = "opening_balance"
+	// EntryAdjustment brings the ledger of a wallet back in line with its
+	// balance after reconciliation found them apart.
+	EntryAdjustment = "adjustment"
+)
+
+// LedgerAccount is identified by a readable code such as "wallet:<id>" or
+// "external:RUB"; see WalletAccountCode, HoldAccountCode and
+// ExternalAccountCode.
+type LedgerAccount struct {
+	Code      string         `gorm:"type:varchar(64);primaryKey" json:"code"`
+	Kind      string         `gorm:"type:varchar(16);not null" json:"kind"`
+	WalletID  *uuid.UUID     `gorm:"type:uuid;index" json:"walletId,omitempty"`
+	Currency  enums.Currency `gorm:"type:char(3);not null" json:"currency"`
+	CreatedAt time.Time      `gorm:"not null" json:"createdAt"`
+}
+
+// JournalEntry groups the postings of one money movement. Its postings
+// always sum to zero, which the database checks when the transaction
+// commits. TransactionID points at the operation record (the debit side of a
+// transfer) that caused it.
+type JournalEntry struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	Kind          string     `gorm:"type:varchar(32);not null" json:"kind"`
+	TransactionID *uuid.UUID `gorm:"type:uuid;index" json:"transactionId,omitempty"`
+	CreatedAt     time.Time  `gorm:"not null;index" json:"createdAt"`
+	Postings      []Posting  `gorm:"foreignKey:EntryID" json:"postings,omitempty"`
+}
+
+// Posting moves Amount into (positive) or out of (negative) an account. The
+// balance of an account is the sum of its postings.
+type Posting struct {
+	ID          int64     `gorm:"primaryKey" json:"id"`
+	EntryID     uuid.UUID `gorm:"type:uuid;not null;index" json:"entryId"`
+	AccountCode string    `gorm:"type:varchar(64);not null;index" json:"accountCode"`
+	Amount      int64     `gorm:"not null" json:"amount"`
+	CreatedAt   time.Time `gorm:"not null" json:"createdAt"`
+}
+
+func WalletAccountCode(walletID uuid.UUID) string {
+	return AccountWallet + ":" + walletID.String()
+}
+
+func HoldAccountCode(walletID uuid.UUID) string {
+	return AccountHold + ":" + walletID.String()
+}
+
+func ExternalAccountCode(currency enums.Currency) string {
+	return AccountExternal + ":" + string(currency)
+}
+
+func AdjustmentAccountCode(currency enums.Currency) string {
+	return AccountAdjustment + ":" + string(currency)
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	enums "itk-academy-test/internal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Domain event types.
+const (
+	EventWalletCreated  = "wallet.created"
+	EventWalletDeleted  = "wallet.deleted"
+	EventWalletCredited = "wallet.credited"
+	EventWalletDebited  = "wallet.debited"
+)
+
+// WalletEventTypes lists every domain event type, for webhook subscriptions
+// to choose from.
+var WalletEventTypes = []string{EventWalletCreated, EventWalletDeleted, EventWalletCredited, EventWalletDebited}
+
+// OutboxEvent is a domain event written in the same DB transaction as the
+// change it describes and published afterwards by the outbox relay. Events
+// are delivered at least once; consumers deduplicate them by ID.
+//
+// Seq is assigned by the database and orders the events for publishing.
+// NextAttemptAt is when the relay may claim the event: after a failed
+// attempt it is pushed back by the retry backoff, and while a relay run
+// holds the event it is the end of that run's lease.
+type OutboxEvent struct {
+	ID            uuid.UUID       `gorm:"type:uuid;primaryKey" json:"id"`
+	Seq           int64           `gorm:"->;index:idx_outbox_events_pending,where:published_at IS NULL" json:"-"`
+	Type          string          `gorm:"type:varchar(64);not null" json:"type"`
+	WalletID      uuid.UUID       `gorm:"type:uuid;not null;index" json:"walletId"`
+	Payload       json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	CreatedAt     time.Time       `gorm:"not null" json:"createdAt"`
+	PublishedAt   *time.Time      `json:"-"`
+	Attempts      int             `gorm:"not null;default:0" json:"-"`
+	NextAttemptAt time.Time       `gorm:"not null;default:now()" json:"-"`
+	LastError     *string         `gorm:"type:text" json:"-"`
+}
+
+// WalletEvent is the payload of the wallet events. For credits and debits
+// Amount is the absolute balance change and TransactionID the operation
+// record that caused it (the debit side, for a transfer); Balance and Held
+// are the amounts the wallet was left with.
+type WalletEvent struct {
+	WalletID      uuid.UUID           `json:"walletId"`
+	Currency      enums.Currency      `json:"currency"`
+	Amount        int64               `json:"amount,omitempty"`
+	Balance       int64               `json:"balance"`
+	Held          int64               `json:"held"`
+	OperationType enums.OperationType `json:"operationType,omitempty"`
+	TransactionID *uuid.UUID          `json:"transactionId,omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	enums "itk-academy-test/internal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Transaction struct {
+	ID             uuid.UUID           `gorm:"type:uuid;primaryKey" json:"id"`
+	WalletID       uuid.UUID           `gorm:"type:uuid;not null;index" json:"walletId"`
+	OperationType  enums.OperationType `gorm:"type:varchar(32);not null" json:"operationType"`
+	Amount         int64               `gorm:"not null" json:"amount"`
+	BalanceBefore  int64               `gorm:"not null" json:"balanceBefore"`
+	BalanceAfter   int64               `gorm:"not null" json:"balanceAfter"`
+	Currency       enums.Currency      `gorm:"type:char(3);not null;default:'RUB'" json:"currency"`
+	IdempotencyKey *string             `gorm:"type:varchar(255);uniqueIndex" json:"idempotencyKey,omitempty"`
+	CounterpartyID *uuid.UUID          `gorm:"type:uuid" json:"counterpartyId,omitempty"`
+	HoldID         *uuid.UUID          `gorm:"type:uuid" json:"holdId,omitempty"`
+	HeldAfter      int64               `gorm:"not null;default:0" json:"heldAfter"`
+	CreatedAt      time.Time           `gorm:"not null;index" json:"createdAt"`
+}
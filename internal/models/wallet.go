@@ -1,8 +1,32 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	enums "itk-academy-test/internal"
+	"time"
 
+	"github.com/google/uuid"
+)
+
+// Wallet balances are kept in minor units of the wallet currency
+// (cents, kopecks).
+//
+// Held is the sum of the wallet's active holds. It is part of Balance but
+// cannot be withdrawn or transferred; see Available.
+//
+// Version is bumped by every balance change; the optimistic concurrency mode
+// writes conditionally on it.
+//
+// The composite indexes back the keyset pagination of the wallet listing.
 type Wallet struct {
-	ID      uuid.UUID `gorm:"type:uuid;primaryKey"`
-	Balance int       `gorm:"not null;default:0" json:"balance"`
+	ID        uuid.UUID      `gorm:"type:uuid;primaryKey;index:idx_wallets_balance_id,priority:2;index:idx_wallets_created_at_id,priority:2"`
+	Balance   int64          `gorm:"not null;default:0;index:idx_wallets_balance_id,priority:1" json:"balance"`
+	Held      int64          `gorm:"not null;default:0" json:"held"`
+	Currency  enums.Currency `gorm:"type:char(3);not null;default:'RUB'" json:"currency"`
+	Version   int64          `gorm:"not null;default:0" json:"-"`
+	CreatedAt time.Time      `gorm:"not null;default:CURRENT_TIMESTAMP;index:idx_wallets_created_at_id,priority:1" json:"createdAt"`
+}
+
+// Available is the part of the balance not reserved by holds.
+func (w *Wallet) Available() int64 {
+	return w.Balance - w.Held
 }
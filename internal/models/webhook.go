@@ -0,0 +1,73 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	enums "itk-academy-test/internal"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventTypes is a list of event types stored as a JSON array.
+type EventTypes []string
+
+func (t EventTypes) Value() (driver.Value, error) {
+	if t == nil {
+		t = EventTypes{}
+	}
+	data, err := json.Marshal([]string(t))
+	return string(data), err
+}
+
+func (t *EventTypes) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case nil:
+		*t = nil
+		return nil
+	default:
+		return errors.New("unsupported type for EventTypes")
+	}
+}
+
+// WebhookSubscription asks for the events of EventTypes to be POSTed to URL,
+// signed with Secret. Only active subscriptions receive new events.
+type WebhookSubscription struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	URL        string     `gorm:"type:text;not null" json:"url"`
+	Secret     string     `gorm:"type:varchar(128);not null" json:"-"`
+	EventTypes EventTypes `gorm:"type:jsonb;not null" json:"eventTypes"`
+	Active     bool       `gorm:"not null;default:true" json:"active"`
+	CreatedAt  time.Time  `gorm:"not null" json:"createdAt"`
+	UpdatedAt  time.Time  `gorm:"not null" json:"updatedAt"`
+}
+
+// Wants reports whether the subscription receives events of eventType.
+func (s *WebhookSubscription) Wants(eventType string) bool {
+	return s.Active && slices.Contains(s.EventTypes, eventType)
+}
+
+// WebhookDelivery is one event on its way to one subscription, and its log:
+// how many attempts were made and how the last one went. Body is the request
+// body every attempt sends and signs.
+type WebhookDelivery struct {
+	ID             uuid.UUID            `gorm:"type:uuid;primaryKey" json:"id"`
+	SubscriptionID uuid.UUID            `gorm:"type:uuid;not null;uniqueIndex:idx_webhook_deliveries_subscription_event,priority:1;index:idx_webhook_deliveries_subscription_created,priority:1" json:"subscriptionId"`
+	EventID        uuid.UUID            `gorm:"type:uuid;not null;uniqueIndex:idx_webhook_deliveries_subscription_event,priority:2" json:"eventId"`
+	EventType      string               `gorm:"type:varchar(64);not null" json:"eventType"`
+	Body           json.RawMessage      `gorm:"type:jsonb;not null" json:"body"`
+	Status         enums.DeliveryStatus `gorm:"type:varchar(16);not null;index:idx_webhook_deliveries_due,priority:1" json:"status"`
+	Attempts       int                  `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time            `gorm:"not null;index:idx_webhook_deliveries_due,priority:2" json:"nextAttemptAt"`
+	LastStatusCode *int                 `json:"lastStatusCode,omitempty"`
+	LastError      *string              `gorm:"type:text" json:"lastError,omitempty"`
+	DeliveredAt    *time.Time           `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time            `gorm:"not null;index:idx_webhook_deliveries_subscription_created,priority:2" json:"createdAt"`
+	UpdatedAt      time.Time            `gorm:"not null" json:"updatedAt"`
+}
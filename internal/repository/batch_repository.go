@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/telemetry"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type BatchRepository interface {
+	BatchAtomic(ctx context.Context, steps []BatchStep) error
+}
+
+// BatchStep is one operation of an atomic batch. Apply changes the wallet
+// and, for a transfer, the credited wallet in memory; to is nil unless
+// ToWalletID is set. Record, when not nil, is stored with the balances Apply
+// left behind, and the credit side of a transfer is derived from it. Every
+// step gets its own journal entry.
+//
+// Apply may return ErrSkipStep, after leaving the wallets untouched, to drop
+// its step without failing the batch; nothing is recorded for it.
+type BatchStep struct {
+	WalletID   uuid.UUID
+	ToWalletID *uuid.UUID
+	Record     *models.Transaction
+	Apply      func(w, to *models.Wallet) error
+}
+
+// ErrSkipStep is returned by BatchStep.Apply to leave its step out.
+var ErrSkipStep = errors.New("batch step skipped")
+
+// BatchStepError reports the step that made an atomic batch fail.
+type BatchStepError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchStepError) Error() string {
+	return fmt.Sprintf("batch step %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchStepError) Unwrap() error {
+	return e.Err
+}
+
+// BatchAtomic applies steps in order in a single DB transaction: either all
+// of them are stored or none is. Every wallet the batch touches is loaded
+// once, in ascending ID order like TransferAtomic, so that batches sharing
+// wallets cannot deadlock; later steps see the changes of earlier ones. A
+// failing step is reported as a *BatchStepError.
+func (r *WalletGORMRepository) BatchAtomic(ctx context.Context, steps []BatchStep) (err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.BatchAtomic",
+		trace.WithAttributes(attribute.Int("batch.size", len(steps))))
+	defer func() { telemetry.End(span, err) }()
+
+	firstStep := make(map[uuid.UUID]int)
+	ids := make([]uuid.UUID, 0, len(steps))
+	for i, step := range steps {
+		for _, id := range step.walletIDs() {
+			if _, ok := firstStep[id]; !ok {
+				firstStep[id] = i
+				ids = append(ids, id)
+			}
+		}
+	}
+	order := lockOrder(ids...)
+
+	err = r.retryConflicts(ctx, "batch_atomic", func() error {
+		return r.transaction(ctx, "batch_atomic", func(tx *gorm.DB) error {
+			loaded := make(map[uuid.UUID]*models.Wallet, len(order))
+			for _, id := range order {
+				w, err := r.loadWallet(tx, "batch_atomic", id)
+				if err != nil {
+					return &BatchStepError{Index: firstStep[id], Err: translateError(err)}
+				}
+				loaded[id] = w
+			}
+
+			for i, step := range steps {
+				if err := applyStep(tx, loaded, step); err != nil {
+					return &BatchStepError{Index: i, Err: err}
+				}
+			}
+
+			for _, id := range order {
+				if err := r.storeWallet(tx, loaded[id]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	return translateError(err)
+}
+
+func (s BatchStep) walletIDs() []uuid.UUID {
+	if s.ToWalletID == nil {
+		return []uuid.UUID{s.WalletID}
+	}
+	return []uuid.UUID{s.WalletID, *s.ToWalletID}
+}
+
+func applyStep(tx *gorm.DB, loaded map[uuid.UUID]*models.Wallet, step BatchStep) error {
+	w := loaded[step.WalletID]
+	change := changing(w)
+
+	if step.ToWalletID == nil {
+		if err := step.Apply(w, nil); err != nil {
+			return skipped(err)
+		}
+		if err := recordTransaction(tx, w, change.balanceBefore, step.Record); err != nil {
+			return err
+		}
+		return bookChanges(tx, step.Record, change)
+	}
+
+	to := loaded[*step.ToWalletID]
+	toChange := changing(to)
+
+	if err := step.Apply(w, to); err != nil {
+		return skipped(err)
+	}
+	if err := recordTransfer(tx, w, to, change.balanceBefore, toChange.balanceBefore, step.Record); err != nil {
+		return err
+	}
+	return bookChanges(tx, step.Record, change, toChange)
+}
+
+// skipped turns the ErrSkipStep of a step into success.
+func skipped(err error) error {
+	if errors.Is(err, ErrSkipStep) {
+		return nil
+	}
+	return err
+}
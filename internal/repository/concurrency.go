@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"itk-academy-test/internal/metrics"
+	"itk-academy-test/internal/models"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// ConcurrencyMode selects how OperateAtomic and TransferAtomic protect a
+// wallet against concurrent writers.
+type ConcurrencyMode string
+
+const (
+	// ConcurrencyPessimistic locks the wallet rows with SELECT ... FOR UPDATE
+	// for the whole transaction. Writers to a hot wallet queue up on the lock.
+	ConcurrencyPessimistic ConcurrencyMode = "pessimistic"
+	// ConcurrencyOptimistic reads the wallets without locking and writes
+	// them with an UPDATE conditional on the version read, retrying the
+	// whole transaction when another writer got there first.
+	ConcurrencyOptimistic ConcurrencyMode = "optimistic"
+)
+
+func (m ConcurrencyMode) IsValid() bool {
+	return m == ConcurrencyPessimistic || m == ConcurrencyOptimistic
+}
+
+// RetryPolicy bounds the retries of the optimistic mode. Attempt n waits
+// about BaseDelay * 2^(n-1), capped at MaxDelay, with jitter.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   5 * time.Millisecond,
+	MaxDelay:    100 * time.Millisecond,
+}
+
+// errVersionConflict means that the wallet changed between the read and the
+// conditional update of an optimistic transaction.
+var errVersionConflict = errors.New("wallet version conflict")
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = max(DefaultRetryPolicy.MaxDelay, p.BaseDelay)
+	}
+	return p
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if shift := attempt - 1; shift < 16 && p.BaseDelay<<shift < p.MaxDelay {
+		delay = p.BaseDelay << shift
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (r *WalletGORMRepository) optimistic() bool {
+	return r.Concurrency == ConcurrencyOptimistic
+}
+
+// retryConflicts runs fn until it no longer fails with a version conflict,
+// giving up with ErrConcurrentUpdate once the retry policy is exhausted. In
+// pessimistic mode fn never conflicts and runs once.
+func (r *WalletGORMRepository) retryConflicts(ctx context.Context, method string, fn func() error) error {
+	policy := r.Retry.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !errors.Is(err, errVersionConflict) {
+			return err
+		}
+
+		metrics.VersionConflicts.WithLabelValues(method).Inc()
+		trace.SpanFromContext(ctx).AddEvent("version conflict",
+			trace.WithAttributes(attribute.Int("attempt", attempt)))
+
+		if attempt >= policy.MaxAttempts {
+			return ErrConcurrentUpdate
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// loadWallet reads the wallet for a read-modify-write: locked in
+// pessimistic mode, plain in optimistic mode.
+func (r *WalletGORMRepository) loadWallet(tx *gorm.DB, method string, id uuid.UUID) (*models.Wallet, error) {
+	if !r.optimistic() {
+		return lockWallet(tx, method, id)
+	}
+
+	var w models.Wallet
+	if err := tx.First(&w, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// storeWallet writes the balance and held amount of w and bumps its version.
+// In optimistic mode the write only happens if nobody else bumped the version
+// since w was loaded, and errVersionConflict is returned otherwise.
+func (r *WalletGORMRepository) storeWallet(tx *gorm.DB, w *models.Wallet) error {
+	if !r.optimistic() {
+		return saveWallet(tx, w)
+	}
+
+	result := tx.Model(&models.Wallet{}).
+		Where("id = ? AND version = ?", w.ID, w.Version).
+		Updates(map[string]any{
+			"balance": w.Balance,
+			"held":    w.Held,
+			"version": w.Version + 1,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errVersionConflict
+	}
+
+	w.Version++
+	return nil
+}
+
+// saveWallet writes a wallet locked by the current transaction. The version
+// is bumped so that optimistic writers notice the change.
+func saveWallet(tx *gorm.DB, w *models.Wallet) error {
+	w.Version++
+	return tx.Save(w).Error
+}
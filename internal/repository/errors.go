@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrWalletNotFound          = errors.New("Wallet not found")
+	ErrHoldNotFound            = errors.New("Hold not found")
+	ErrDuplicateIdempotencyKey = errors.New("Idempotency key already used")
+	ErrInsufficientFunds       = errors.New("Insufficient funds")
+	ErrConcurrentUpdate        = errors.New("Wallet is being modified concurrently, retry later")
+	ErrCurrencyMismatch        = errors.New("Currency does not match the wallet currency")
+	ErrUnbalancedEntry         = errors.New("Journal entry postings do not sum to zero")
+	ErrSubscriptionNotFound    = errors.New("Webhook subscription not found")
+	ErrDeliveryNotFound        = errors.New("Webhook delivery not found")
+)
+
+const (
+	uniqueViolationCode = "23505"
+	checkViolationCode  = "23514"
+
+	idempotencyKeyIndex = "idx_transactions_idempotency_key"
+
+	// postingsBalanced is raised by the deferred trigger that checks, when a
+	// transaction commits, that every journal entry sums to zero.
+	postingsBalanced = "postings_balanced"
+)
+
+// balanceConstraints are the wallets CHECK constraints that keep balances
+// non-negative and holds covered. Violating any of them means the wallet
+// cannot afford the change.
+var balanceConstraints = []string{
+	"wallets_balance_non_negative",
+	"wallets_held_within_balance",
+}
+
+// translateError maps driver and GORM errors to the repository's own errors.
+func translateError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrWalletNotFound
+	}
+	if isCheckViolation(err, balanceConstraints...) {
+		return ErrInsufficientFunds
+	}
+	if isCheckViolation(err, postingsBalanced) {
+		return ErrUnbalancedEntry
+	}
+
+	return err
+}
+
+func isCheckViolation(err error, constraints ...string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == checkViolationCode && slices.Contains(constraints, pgErr.ConstraintName)
+}
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == constraint
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/telemetry"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type HoldRepository interface {
+	GetHold(ctx context.Context, id uuid.UUID) (*models.Hold, error)
+	CreateHold(ctx context.Context, hold *models.Hold, record *models.Transaction, fn func(w *models.Wallet) error) (*models.Wallet, error)
+	OperateHold(ctx context.Context, id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet, h *models.Hold) error) (*models.Wallet, *models.Hold, error)
+	ExpiredHolds(ctx context.Context, now time.Time, limit int) ([]models.Hold, error)
+}
+
+func (r *WalletGORMRepository) GetHold(ctx context.Context, id uuid.UUID) (*models.Hold, error) {
+	var hold models.Hold
+
+	err := r.DB.WithContext(ctx).First(&hold, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrHoldNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+// CreateHold locks the wallet of hold, applies fn and stores the wallet, the
+// new hold, the operation record and its journal entry in a single DB
+// transaction.
+func (r *WalletGORMRepository) CreateHold(ctx context.Context, hold *models.Hold, record *models.Transaction, fn func(w *models.Wallet) error) (_ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.CreateHold",
+		trace.WithAttributes(attribute.String("wallet.id", hold.WalletID.String())))
+	defer func() { telemetry.End(span, err) }()
+
+	var result *models.Wallet
+	err = r.transaction(ctx, "create_hold", func(tx *gorm.DB) error {
+		w, err := lockWallet(tx, "create_hold", hold.WalletID)
+		if err != nil {
+			return err
+		}
+
+		change := changing(w)
+
+		if err := fn(w); err != nil {
+			return err
+		}
+
+		if err := saveWallet(tx, w); err != nil {
+			return err
+		}
+
+		hold.ID = uuid.New()
+		hold.Currency = w.Currency
+		if err := tx.Create(hold).Error; err != nil {
+			return err
+		}
+
+		if record != nil {
+			record.HoldID = &hold.ID
+		}
+		if err := recordTransaction(tx, w, change.balanceBefore, record); err != nil {
+			return err
+		}
+		if err := bookChanges(tx, record, change); err != nil {
+			return err
+		}
+
+		result = w
+		return nil
+	})
+	return result, translateError(err)
+}
+
+// OperateHold locks the hold's wallet and then the hold itself, the same
+// order every other wallet operation uses, applies fn and stores both rows
+// together with the operation record and its journal entry in a single DB
+// transaction.
+func (r *WalletGORMRepository) OperateHold(ctx context.Context, id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet, h *models.Hold) error) (_ *models.Wallet, _ *models.Hold, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.OperateHold",
+		trace.WithAttributes(attribute.String("hold.id", id.String())))
+	defer func() { telemetry.End(span, err) }()
+
+	hold, err := r.GetHold(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var wallet *models.Wallet
+	err = r.transaction(ctx, "operate_hold", func(tx *gorm.DB) error {
+		w, err := lockWallet(tx, "operate_hold", hold.WalletID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(hold, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrHoldNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		change := changing(w)
+
+		if err := fn(w, hold); err != nil {
+			return err
+		}
+
+		if err := saveWallet(tx, w); err != nil {
+			return err
+		}
+		if err := tx.Save(hold).Error; err != nil {
+			return err
+		}
+
+		if record != nil {
+			record.HoldID = &hold.ID
+		}
+		if err := recordTransaction(tx, w, change.balanceBefore, record); err != nil {
+			return err
+		}
+		if err := bookChanges(tx, record, change); err != nil {
+			return err
+		}
+
+		wallet = w
+		return nil
+	})
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	return wallet, hold, nil
+}
+
+// ExpiredHolds returns up to limit active holds whose expiry is not after
+// now, oldest expiry first.
+func (r *WalletGORMRepository) ExpiredHolds(ctx context.Context, now time.Time, limit int) ([]models.Hold, error) {
+	var holds []models.Hold
+
+	err := r.DB.WithContext(ctx).
+		Where("status = ? AND expires_at <= ?", enums.HoldActive, now).
+		Order("expires_at").
+		Limit(limit).
+		Find(&holds).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/telemetry"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LedgerRepository interface {
+	Reconcile(ctx context.Context) (*ReconciliationReport, error)
+	AdjustLedger(ctx context.Context, walletID uuid.UUID) (*models.JournalEntry, error)
+}
+
+// ReconciliationReport compares the wallet balances, which are a cached
+// projection of the ledger, with the postings they are projected from.
+type ReconciliationReport struct {
+	Wallets           int64
+	Mismatches        []WalletMismatch
+	UnbalancedEntries []UnbalancedEntry
+}
+
+// Clean reports whether every wallet matches the ledger and every journal
+// entry balances.
+func (r *ReconciliationReport) Clean() bool {
+	return len(r.Mismatches) == 0 && len(r.UnbalancedEntries) == 0
+}
+
+// WalletMismatch is a wallet whose balance or held amount differs from the
+// sum of the postings to its accounts.
+type WalletMismatch struct {
+	WalletID      uuid.UUID
+	Currency      enums.Currency
+	Balance       int64
+	Held          int64
+	LedgerBalance int64
+	LedgerHeld    int64
+}
+
+// UnbalancedEntry is a journal entry whose postings do not sum to zero.
+// The database refuses to commit one, so any found means the ledger was
+// written around it.
+type UnbalancedEntry struct {
+	EntryID uuid.UUID
+	Sum     int64
+}
+
+// walletChange is a wallet together with the amounts it had before the
+// operation that is changing it.
+type walletChange struct {
+	wallet        *models.Wallet
+	balanceBefore int64
+	heldBefore    int64
+}
+
+func changing(w *models.Wallet) walletChange {
+	return walletChange{wallet: w, balanceBefore: w.Balance, heldBefore: w.Held}
+}
+
+// postEntry posts the journal entry of an operation that left the wallets
+// of changes as they are now, with the external accounts on the other side.
+// record, when not nil, is the operation record the entry belongs to.
+// Nothing is posted when no amount changed.
+func postEntry(tx *gorm.DB, record *models.Transaction, changes ...walletChange) error {
+	entry := journalEntry(models.EntryOperation, models.ExternalAccountCode, changes...)
+	if record != nil {
+		entry.TransactionID = &record.ID
+	}
+
+	if len(entry.Postings) == 0 {
+		return nil
+	}
+	return tx.Create(&entry).Error
+}
+
+// journalEntry builds the postings that take the wallets of changes from
+// their amounts before to their amounts now. A change of the held amount is
+// posted to the hold account of the wallet and the rest of the balance change
+// to its wallet account. Whatever the balances gained or lost in total is
+// posted, with the opposite sign, to the counter account of the currency.
+func journalEntry(kind string, counter func(enums.Currency) string, changes ...walletChange) models.JournalEntry {
+	entry := models.JournalEntry{ID: uuid.New(), Kind: kind}
+
+	post := func(code string, amount int64) {
+		if amount != 0 {
+			entry.Postings = append(entry.Postings, models.Posting{AccountCode: code, Amount: amount})
+		}
+	}
+
+	var currencies []enums.Currency
+	totals := make(map[enums.Currency]int64)
+	for _, c := range changes {
+		w := c.wallet
+		held := w.Held - c.heldBefore
+		balance := w.Balance - c.balanceBefore
+
+		post(models.WalletAccountCode(w.ID), balance-held)
+		post(models.HoldAccountCode(w.ID), held)
+
+		if _, ok := totals[w.Currency]; !ok {
+			currencies = append(currencies, w.Currency)
+		}
+		totals[w.Currency] -= balance
+	}
+	for _, currency := range currencies {
+		post(counter(currency), totals[currency])
+	}
+
+	return entry
+}
+
+// openAccounts creates the ledger accounts of a new wallet, and the external
+// account of its currency unless it exists already.
+func openAccounts(tx *gorm.DB, w *models.Wallet) error {
+	accounts := []models.LedgerAccount{
+		{Code: models.WalletAccountCode(w.ID), Kind: models.AccountWallet, WalletID: &w.ID, Currency: w.Currency},
+		{Code: models.HoldAccountCode(w.ID), Kind: models.AccountHold, WalletID: &w.ID, Currency: w.Currency},
+		{Code: models.ExternalAccountCode(w.Currency), Kind: models.AccountExternal, Currency: w.Currency},
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&accounts).Error
+}
+
+// reconcileWalletsSQL sums the postings of the wallet and hold accounts of
+// every wallet and keeps the wallets whose projection differs.
+const reconcileWalletsSQL = `
+SELECT w.id AS wallet_id, w.currency, w.balance, w.held,
+	COALESCE(SUM(p.amount), 0) AS ledger_balance,
+	COALESCE(SUM(p.amount) FILTER (WHERE a.kind = 'hold'), 0) AS ledger_held
+FROM wallets w
+LEFT JOIN ledger_accounts a ON a.wallet_id = w.id
+LEFT JOIN postings p ON p.account_code = a.code
+GROUP BY w.id
+HAVING w.balance <> COALESCE(SUM(p.amount), 0)
+	OR w.held <> COALESCE(SUM(p.amount) FILTER (WHERE a.kind = 'hold'), 0)
+ORDER BY w.id`
+
+// walletLedgerSQL sums the postings to the accounts of one wallet.
+const walletLedgerSQL = `
+SELECT COALESCE(SUM(p.amount), 0) AS balance,
+	COALESCE(SUM(p.amount) FILTER (WHERE a.kind = 'hold'), 0) AS held
+FROM ledger_accounts a
+JOIN postings p ON p.account_code = a.code
+WHERE a.wallet_id = ?`
+
+const unbalancedEntriesSQL = `
+SELECT entry_id, SUM(amount) AS sum
+FROM postings
+GROUP BY entry_id
+HAVING SUM(amount) <> 0
+ORDER BY entry_id`
+
+// Reconcile checks every wallet balance and held amount against the sum of
+// the postings to its accounts, and every journal entry for balance. It
+// reads a single snapshot, so operations running meanwhile cannot show up
+// as mismatches.
+func (r *WalletGORMRepository) Reconcile(ctx context.Context) (_ *ReconciliationReport, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.Reconcile")
+	defer func() { telemetry.End(span, err) }()
+
+	report := &ReconciliationReport{}
+	err = r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Wallet{}).Count(&report.Wallets).Error; err != nil {
+			return err
+		}
+		if err := tx.Raw(reconcileWalletsSQL).Scan(&report.Mismatches).Error; err != nil {
+			return err
+		}
+		return tx.Raw(unbalancedEntriesSQL).Scan(&report.UnbalancedEntries).Error
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(
+		attribute.Int64("reconcile.wallets", report.Wallets),
+		attribute.Int("reconcile.mismatches", len(report.Mismatches)),
+		attribute.Int("reconcile.unbalanced_entries", len(report.UnbalancedEntries)),
+	)
+	return report, nil
+}
+
+// AdjustLedger books the difference between a wallet and the sum of the
+// postings to its accounts as an adjustment entry, with the adjustment
+// account of its currency on the other side, so that the ledger agrees with
+// the wallet again. The wallet balance is left alone: it is what the owner
+// has been shown. The wallet stays locked while the difference is measured
+// and booked. It returns nil when there was nothing to adjust.
+func (r *WalletGORMRepository) AdjustLedger(ctx context.Context, walletID uuid.UUID) (_ *models.JournalEntry, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.AdjustLedger",
+		trace.WithAttributes(attribute.String("wallet.id", walletID.String())))
+	defer func() { telemetry.End(span, err) }()
+
+	var entry *models.JournalEntry
+	err = r.transaction(ctx, "adjust_ledger", func(tx *gorm.DB) error {
+		w, err := lockWallet(tx, "adjust_ledger", walletID)
+		if err != nil {
+			return err
+		}
+
+		var ledger struct {
+			Balance int64
+			Held    int64
+		}
+		if err := tx.Raw(walletLedgerSQL, walletID).Scan(&ledger).Error; err != nil {
+			return err
+		}
+		if w.Balance == ledger.Balance && w.Held == ledger.Held {
+			return nil
+		}
+
+		if err := openAccounts(tx, w); err != nil {
+			return err
+		}
+		adjustment := models.LedgerAccount{
+			Code:     models.AdjustmentAccountCode(w.Currency),
+			Kind:     models.AccountAdjustment,
+			Currency: w.Currency,
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&adjustment).Error; err != nil {
+			return err
+		}
+
+		adjusted := journalEntry(models.EntryAdjustment, models.AdjustmentAccountCode,
+			walletChange{wallet: w, balanceBefore: ledger.Balance, heldBefore: ledger.Held})
+		if err := tx.Create(&adjusted).Error; err != nil {
+			return err
+		}
+
+		entry = &adjusted
+		return nil
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return entry, nil
+}
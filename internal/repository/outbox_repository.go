@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/telemetry"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type OutboxRepository interface {
+	PublishEvents(ctx context.Context, limit int, publish func(ctx context.Context, event models.OutboxEvent) error) (int, error)
+}
+
+// bookChanges records the side effects every balance change shares: the
+// journal entry of the operation and the domain events of the wallets it
+// changed.
+func bookChanges(tx *gorm.DB, record *models.Transaction, changes ...walletChange) error {
+	if err := postEntry(tx, record, changes...); err != nil {
+		return err
+	}
+	return queueChangeEvents(tx, record, changes...)
+}
+
+// queueChangeEvents writes a credited or debited event for every wallet of
+// changes whose balance moved. Changes of the held amount alone are not
+// published.
+func queueChangeEvents(tx *gorm.DB, record *models.Transaction, changes ...walletChange) error {
+	events := make([]models.OutboxEvent, 0, len(changes))
+	for _, c := range changes {
+		w := c.wallet
+		delta := w.Balance - c.balanceBefore
+		if delta == 0 {
+			continue
+		}
+
+		eventType := models.EventWalletCredited
+		if delta < 0 {
+			eventType, delta = models.EventWalletDebited, -delta
+		}
+
+		payload := models.WalletEvent{WalletID: w.ID, Currency: w.Currency, Amount: delta, Balance: w.Balance, Held: w.Held}
+		if record != nil {
+			payload.OperationType = record.OperationType
+			payload.TransactionID = &record.ID
+		}
+
+		event, err := walletEvent(eventType, payload)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+	return tx.Create(&events).Error
+}
+
+func queueEvent(tx *gorm.DB, eventType string, w *models.Wallet) error {
+	event, err := walletEvent(eventType, models.WalletEvent{
+		WalletID: w.ID,
+		Currency: w.Currency,
+		Balance:  w.Balance,
+		Held:     w.Held,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Create(&event).Error
+}
+
+func walletEvent(eventType string, payload models.WalletEvent) (models.OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.OutboxEvent{}, err
+	}
+
+	now := time.Now()
+	return models.OutboxEvent{
+		ID:            uuid.New(),
+		Type:          eventType,
+		WalletID:      payload.WalletID,
+		Payload:       data,
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}, nil
+}
+
+// OutboxPolicy governs the outbox relay. A run leases the events it claims
+// for Lease: no other run claims them before then, and the run stops
+// publishing once it is over. After failed attempt n an event is due again
+// BaseDelay * 2^(n-1) later, capped at MaxDelay.
+type OutboxPolicy struct {
+	Lease     time.Duration
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+var DefaultOutboxPolicy = OutboxPolicy{
+	Lease:     time.Minute,
+	BaseDelay: time.Second,
+	MaxDelay:  10 * time.Minute,
+}
+
+func (p OutboxPolicy) withDefaults() OutboxPolicy {
+	if p.Lease <= 0 {
+		p.Lease = DefaultOutboxPolicy.Lease
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultOutboxPolicy.BaseDelay
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = max(DefaultOutboxPolicy.MaxDelay, p.BaseDelay)
+	}
+	return p
+}
+
+func (p OutboxPolicy) backoff(attempt int) time.Duration {
+	if shift := max(attempt-1, 0); shift < 32 && p.BaseDelay<<shift < p.MaxDelay {
+		return p.BaseDelay << shift
+	}
+	return p.MaxDelay
+}
+
+// claimEventsSQL leases due events by moving their next attempt to the end
+// of the lease. SKIP LOCKED lets relays running side by side claim
+// different events.
+const claimEventsSQL = `
+UPDATE outbox_events
+SET next_attempt_at = @lease_until
+WHERE id IN (
+	SELECT id FROM outbox_events
+	WHERE published_at IS NULL AND next_attempt_at <= @now
+	ORDER BY seq
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// PublishEvents claims up to limit due events, passes them to publish in
+// the order they were written and marks the ones it accepted as published.
+// No DB transaction is open while publish runs, and publish is given a ctx
+// that ends with the lease.
+//
+// The first event publish rejects stops the run: its attempt and error are
+// recorded and it is due again after the backoff of r.Outbox, while the
+// events after it are handed back to be claimed by the next run. A failing
+// event therefore does not hold up the others, and events are published in
+// order only as long as none fails. An event whose run died before marking
+// it is claimed again once its lease runs out; delivery is at least once.
+func (r *WalletGORMRepository) PublishEvents(ctx context.Context, limit int, publish func(ctx context.Context, event models.OutboxEvent) error) (_ int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.PublishEvents",
+		trace.WithAttributes(attribute.Int("outbox.limit", limit)))
+	defer func() { telemetry.End(span, err) }()
+
+	policy := r.Outbox.withDefaults()
+	now := time.Now()
+	leaseUntil := now.Add(policy.Lease)
+
+	var claimed []models.OutboxEvent
+	err = r.DB.WithContext(ctx).Raw(claimEventsSQL, map[string]any{
+		"lease_until": leaseUntil,
+		"now":         now,
+		"limit":       limit,
+	}).Scan(&claimed).Error
+	if err != nil {
+		return 0, err
+	}
+	slices.SortFunc(claimed, func(a, b models.OutboxEvent) int { return cmp.Compare(a.Seq, b.Seq) })
+
+	publishCtx, cancel := context.WithDeadline(ctx, leaseUntil)
+	defer cancel()
+
+	var published, rest []uuid.UUID
+	var failed *models.OutboxEvent
+	var publishErr error
+	for i := range claimed {
+		if publishErr = publish(publishCtx, claimed[i]); publishErr != nil {
+			// An attempt cut short by the lease or by ctx says nothing
+			// about the event and is not counted.
+			if publishCtx.Err() == nil {
+				failed = &claimed[i]
+			} else {
+				rest = append(rest, claimed[i].ID)
+			}
+			for _, event := range claimed[i+1:] {
+				rest = append(rest, event.ID)
+			}
+			break
+		}
+		published = append(published, claimed[i].ID)
+	}
+
+	err = r.transaction(ctx, "publish_events", func(tx *gorm.DB) error {
+		now := time.Now()
+		if len(published) > 0 {
+			err := tx.Model(&models.OutboxEvent{}).
+				Where("id IN ?", published).
+				Update("published_at", now).Error
+			if err != nil {
+				return err
+			}
+		}
+		if failed != nil {
+			err := tx.Model(&models.OutboxEvent{ID: failed.ID}).Updates(map[string]any{
+				"attempts":        gorm.Expr("attempts + 1"),
+				"last_error":      publishErr.Error(),
+				"next_attempt_at": now.Add(policy.backoff(failed.Attempts + 1)),
+			}).Error
+			if err != nil {
+				return err
+			}
+			logging.FromContext(ctx).Warn("Failed to publish outbox event",
+				"event_id", failed.ID, "type", failed.Type, "attempts", failed.Attempts+1, "error", publishErr)
+		}
+		if len(rest) > 0 {
+			// Events whose lease ran out may have been claimed by
+			// another run already; their new lease is left alone.
+			return tx.Model(&models.OutboxEvent{}).
+				Where("id IN ? AND next_attempt_at = ?", rest, leaseUntil).
+				Update("next_attempt_at", now).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.Int("outbox.published", len(published)))
+	return len(published), publishErr
+}
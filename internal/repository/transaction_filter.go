@@ -0,0 +1,22 @@
+package repository
+
+import (
+	enums "itk-academy-test/internal"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TransactionFilter struct {
+	WalletID      uuid.UUID
+	OperationType enums.OperationType
+	From          *time.Time
+	To            *time.Time
+
+	// AfterCreatedAt and AfterID form the keyset cursor: only rows strictly
+	// older than this position are returned.
+	AfterCreatedAt *time.Time
+	AfterID        uuid.UUID
+
+	Limit int
+}
@@ -0,0 +1,30 @@
+package repository
+
+import (
+	enums "itk-academy-test/internal"
+
+	"github.com/google/uuid"
+)
+
+type WalletSortField string
+
+const (
+	SortByBalance   WalletSortField = "balance"
+	SortByCreatedAt WalletSortField = "created_at"
+)
+
+type WalletFilter struct {
+	SortBy     WalletSortField
+	Desc       bool
+	MinBalance *int64
+	MaxBalance *int64
+	Currency   enums.Currency
+
+	// After and AfterID form the keyset cursor: the sort column value and ID
+	// of the last row of the previous page. After is an int64 for
+	// SortByBalance and a time.Time for SortByCreatedAt.
+	After   any
+	AfterID uuid.UUID
+
+	Limit int
+}
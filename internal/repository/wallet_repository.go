@@ -1,91 +1,518 @@
 package repository
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/metrics"
 	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/telemetry"
+	"slices"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+const tracerName = "itk-academy-test/internal/repository"
+
 type WalletRepository interface {
-	Create() (models.Wallet, error)
-	Update(*models.Wallet) (*models.Wallet, error)
-	Delete(id uuid.UUID) error
-	Get(id uuid.UUID) (*models.Wallet, error)
+	Create(ctx context.Context, currency enums.Currency) (models.Wallet, error)
+	Update(ctx context.Context, wallet *models.Wallet) (*models.Wallet, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Get(ctx context.Context, id uuid.UUID) (*models.Wallet, error)
+
+	List(ctx context.Context, filter WalletFilter) ([]models.Wallet, int64, error)
+	Transactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, error)
+	TransactionByIdempotencyKey(ctx context.Context, key string) (*models.Transaction, error)
+	OperateAtomic(ctx context.Context, id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet) error) (*models.Wallet, error)
+	AdjustBalance(ctx context.Context, id uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (*models.Wallet, error)
+	TransferAtomic(ctx context.Context, fromID, toID uuid.UUID, record *models.Transaction, fn func(from, to *models.Wallet) error) (*models.Wallet, *models.Wallet, error)
 
-	AllWallets() (*[]models.Wallet, error)
-	OperateAtomic(id uuid.UUID, fn func(w *models.Wallet) error) (*models.Wallet, error)
+	HoldRepository
+	BatchRepository
+	LedgerRepository
+	OutboxRepository
 }
 
+// WalletGORMRepository stores wallets in Postgres. Concurrency selects the
+// locking strategy of OperateAtomic and TransferAtomic (pessimistic when
+// empty); Retry bounds the retries of the optimistic one. Outbox governs
+// PublishEvents; unset fields take their DefaultOutboxPolicy value.
 type WalletGORMRepository struct {
-	DB *gorm.DB
+	DB          *gorm.DB
+	Concurrency ConcurrencyMode
+	Retry       RetryPolicy
+	Outbox      OutboxPolicy
 }
 
-func (r *WalletGORMRepository) Create() (models.Wallet, error) {
-	wallet := models.Wallet{ID: uuid.New()}
-	err := r.DB.Create(&wallet).Error
+// Create stores a new wallet together with its ledger accounts and its
+// created event.
+func (r *WalletGORMRepository) Create(ctx context.Context, currency enums.Currency) (models.Wallet, error) {
+	wallet := models.Wallet{ID: uuid.New(), Currency: currency}
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&wallet).Error; err != nil {
+			return err
+		}
+		if err := openAccounts(tx, &wallet); err != nil {
+			return err
+		}
+		return queueEvent(tx, models.EventWalletCreated, &wallet)
+	})
 	return wallet, err
 }
 
-func (r *WalletGORMRepository) Update(wallet *models.Wallet) (*models.Wallet, error) {
-
-	err := r.DB.Save(wallet).Error
+// Update stores the wallet attributes other than its balance and held
+// amount, which only change through recorded operations such as
+// OperateAtomic, and returns the wallet as stored.
+func (r *WalletGORMRepository) Update(ctx context.Context, wallet *models.Wallet) (*models.Wallet, error) {
+	err := r.DB.WithContext(ctx).
+		Model(&models.Wallet{ID: wallet.ID}).
+		Omit("id", "balance", "held", "version", "created_at").
+		Updates(wallet).Error
 	if err != nil {
-		return wallet, err
+		return nil, translateError(err)
 	}
 
-	return wallet, nil
+	return r.Get(ctx, wallet.ID)
 }
 
-func (r *WalletGORMRepository) Delete(id uuid.UUID) error {
-	return r.DB.Delete(models.Wallet{}, id).Error
+// Delete removes the wallet, expires its active holds, which the expiry worker
+// could no longer release without the wallet, and queues its deleted event,
+// which carries the balance the wallet had.
+func (r *WalletGORMRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var wallet models.Wallet
+		result := tx.Clauses(clause.Returning{}).Delete(&wallet, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrWalletNotFound
+		}
+
+		err := tx.Model(&models.Hold{}).
+			Where("wallet_id = ? AND status = ?", id, enums.HoldActive).
+			Update("status", enums.HoldExpired).Error
+		if err != nil {
+			return err
+		}
+
+		return queueEvent(tx, models.EventWalletDeleted, &wallet)
+	})
 }
 
-func (r *WalletGORMRepository) Get(id uuid.UUID) (*models.Wallet, error) {
+func (r *WalletGORMRepository) Get(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
 	var wallet models.Wallet
 
-	err := r.DB.First(&wallet, id).Error
+	err := r.DB.WithContext(ctx).First(&wallet, id).Error
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &wallet, nil
 }
 
-func (r *WalletGORMRepository) OperateAtomic(id uuid.UUID, fn func(w *models.Wallet) error) (*models.Wallet, error) {
+// OperateAtomic loads the wallet, applies fn and stores the resulting
+// balance together with the operation record and its journal entry in a
+// single DB transaction.
+// Depending on r.Concurrency the wallet row is either locked up front or
+// written conditionally on its version, in which case fn may run more than
+// once.
+func (r *WalletGORMRepository) OperateAtomic(ctx context.Context, id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet) error) (_ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.OperateAtomic",
+		trace.WithAttributes(attribute.String("wallet.id", id.String())))
+	defer func() { telemetry.End(span, err) }()
+
 	var result *models.Wallet
-	err := r.DB.Transaction(func(tx *gorm.DB) error {
-		var w models.Wallet
+	err = r.retryConflicts(ctx, "operate_atomic", func() error {
+		return r.transaction(ctx, "operate_atomic", func(tx *gorm.DB) error {
+			w, err := r.loadWallet(tx, "operate_atomic", id)
+			if err != nil {
+				return err
+			}
 
-		if err := tx.
-			Clauses(clause.Locking{Strength: "UPDATE"}).
-			First(&w, "id = ?", id).Error; err != nil {
-			return err
-		}
+			change := changing(w)
 
-		if err := fn(&w); err != nil {
-			return err
-		}
+			if err := fn(w); err != nil {
+				return err
+			}
 
-		if err := tx.Save(&w).Error; err != nil {
-			return err
+			if err := r.storeWallet(tx, w); err != nil {
+				return err
+			}
+
+			if err := recordTransaction(tx, w, change.balanceBefore, record); err != nil {
+				return err
+			}
+			if err := bookChanges(tx, record, change); err != nil {
+				return err
+			}
+
+			result = w
+			return nil
+		})
+	})
+	return result, translateError(err)
+}
+
+// adjustBalanceSQL moves the balance by @delta and records the operation,
+// its journal entry and its event in one statement. The entry posts @delta
+// to the wallet account and its opposite to the external account of the
+// currency, as journalEntry would; the account codes mirror
+// models.WalletAccountCode and models.ExternalAccountCode. The event payload
+// mirrors models.WalletEvent. The row lock is taken and released by
+// the UPDATE itself, and no row comes back when the wallet is missing, holds
+// another currency or cannot cover a negative delta with its available
+// (unheld) funds.
+const adjustBalanceSQL = `
+WITH updated AS (
+	UPDATE wallets
+	SET balance = balance + @delta, version = version + 1
+	WHERE id = @id
+		AND balance - held + @delta >= 0
+		AND (CAST(@currency AS text) = '' OR currency = @currency)
+	RETURNING *
+), recorded AS (
+	INSERT INTO transactions (id, wallet_id, operation_type, amount, balance_before, balance_after, currency, idempotency_key, held_after, created_at)
+	SELECT CAST(@record_id AS uuid), id, CAST(@operation_type AS varchar), CAST(@amount AS bigint),
+		balance - @delta, balance, currency, CAST(@idempotency_key AS varchar), held, CAST(@created_at AS timestamptz)
+	FROM updated
+), entry AS (
+	INSERT INTO journal_entries (id, kind, transaction_id, created_at)
+	SELECT CAST(@entry_id AS uuid), CAST(@entry_kind AS varchar), CAST(@record_id AS uuid), CAST(@created_at AS timestamptz)
+	FROM updated
+	RETURNING id, created_at
+), posted AS (
+	INSERT INTO postings (entry_id, account_code, amount, created_at)
+	SELECT entry.id, p.account_code, p.amount, entry.created_at
+	FROM entry, updated, LATERAL (VALUES
+		('wallet:' || updated.id, CAST(@delta AS bigint)),
+		('external:' || updated.currency, -CAST(@delta AS bigint))
+	) AS p (account_code, amount)
+), queued AS (
+	INSERT INTO outbox_events (id, type, wallet_id, payload, created_at)
+	SELECT CAST(@event_id AS uuid), CAST(@event_type AS varchar), id,
+		jsonb_build_object(
+			'walletId', id,
+			'currency', CAST(currency AS text),
+			'amount', abs(CAST(@delta AS bigint)),
+			'balance', balance,
+			'held', held,
+			'operationType', CAST(@operation_type AS text),
+			'transactionId', CAST(@record_id AS uuid)
+		),
+		CAST(@created_at AS timestamptz)
+	FROM updated
+)
+SELECT * FROM updated`
+
+// AdjustBalance adds delta to the wallet balance and stores record with a
+// single UPDATE ... RETURNING, without a read-modify-write round trip. A
+// negative delta must be covered by the available funds. currency, when not
+// empty, must match the wallet currency. It works the same in both
+// concurrency modes; operations that need more than a balance delta go
+// through OperateAtomic.
+func (r *WalletGORMRepository) AdjustBalance(ctx context.Context, id uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (_ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.AdjustBalance",
+		trace.WithAttributes(
+			attribute.String("wallet.id", id.String()),
+			attribute.Int64("wallet.delta", delta),
+		))
+	defer func() { telemetry.End(span, err) }()
+
+	record.ID = uuid.New()
+	record.CreatedAt = time.Now()
+
+	eventType := models.EventWalletCredited
+	if delta < 0 {
+		eventType = models.EventWalletDebited
+	}
+
+	var w models.Wallet
+
+	start := time.Now()
+	result := r.DB.WithContext(ctx).Raw(adjustBalanceSQL,
+		sql.Named("id", id),
+		sql.Named("delta", delta),
+		sql.Named("currency", string(currency)),
+		sql.Named("record_id", record.ID),
+		sql.Named("operation_type", record.OperationType),
+		sql.Named("amount", record.Amount),
+		sql.Named("idempotency_key", record.IdempotencyKey),
+		sql.Named("created_at", record.CreatedAt),
+		sql.Named("entry_id", uuid.New()),
+		sql.Named("entry_kind", models.EntryOperation),
+		sql.Named("event_id", uuid.New()),
+		sql.Named("event_type", eventType),
+	).Scan(&w)
+	metrics.ObserveTransaction("adjust_balance", start, result.Error)
+
+	if result.Error != nil {
+		if isUniqueViolation(result.Error, idempotencyKeyIndex) {
+			return nil, ErrDuplicateIdempotencyKey
 		}
-		result = &w
-		return nil
+		return nil, translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, r.adjustRejected(ctx, id, currency)
+	}
+
+	record.WalletID = w.ID
+	record.BalanceBefore = w.Balance - delta
+	record.BalanceAfter = w.Balance
+	record.HeldAfter = w.Held
+	record.Currency = w.Currency
+
+	return &w, nil
+}
+
+// adjustRejected tells why AdjustBalance matched no row. The wallet is read
+// after the fact, so a concurrent change may be reflected, but the answer
+// is always one that was true at some point.
+func (r *WalletGORMRepository) adjustRejected(ctx context.Context, id uuid.UUID, currency enums.Currency) error {
+	w, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if currency != "" && w.Currency != currency {
+		return ErrCurrencyMismatch
+	}
+	return ErrInsufficientFunds
+}
+
+// TransferAtomic loads both wallets, applies fn and records the debit and
+// the credit side, under one journal entry, in a single DB transaction.
+// Rows are locked (pessimistic mode) or written (optimistic mode) in
+// ascending ID order, so that opposite transfers between the same pair
+// cannot deadlock. record describes the debit side; the credit side is
+// derived from it.
+func (r *WalletGORMRepository) TransferAtomic(ctx context.Context, fromID, toID uuid.UUID, record *models.Transaction, fn func(from, to *models.Wallet) error) (_, _ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletGORMRepository.TransferAtomic",
+		trace.WithAttributes(
+			attribute.String("wallet.id", fromID.String()),
+			attribute.String("wallet.to_id", toID.String()),
+		))
+	defer func() { telemetry.End(span, err) }()
+
+	var from, to *models.Wallet
+	err = r.retryConflicts(ctx, "transfer_atomic", func() error {
+		return r.transaction(ctx, "transfer_atomic", func(tx *gorm.DB) error {
+			order := lockOrder(fromID, toID)
+
+			loaded := make(map[uuid.UUID]*models.Wallet, 2)
+			for _, id := range order {
+				w, err := r.loadWallet(tx, "transfer_atomic", id)
+				if err != nil {
+					return err
+				}
+				loaded[id] = w
+			}
+
+			from, to = loaded[fromID], loaded[toID]
+			fromChange, toChange := changing(from), changing(to)
+
+			if err := fn(from, to); err != nil {
+				return err
+			}
+
+			for _, id := range order {
+				if err := r.storeWallet(tx, loaded[id]); err != nil {
+					return err
+				}
+			}
+
+			if err := recordTransfer(tx, from, to, fromChange.balanceBefore, toChange.balanceBefore, record); err != nil {
+				return err
+			}
+			return bookChanges(tx, record, fromChange, toChange)
+		})
+	})
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	return from, to, nil
+}
+
+// transaction runs fn in a DB transaction and records its duration under
+// method.
+func (r *WalletGORMRepository) transaction(ctx context.Context, method string, fn func(tx *gorm.DB) error) error {
+	start := time.Now()
+	err := r.DB.WithContext(ctx).Transaction(fn)
+	metrics.ObserveTransaction(method, start, err)
+
+	logging.FromContext(ctx).Debug("DB transaction finished",
+		"method", method, "duration", time.Since(start), "committed", err == nil)
+	return err
+}
+
+// lockWallet selects the wallet FOR UPDATE in its own span, so that the time
+// spent waiting for the row lock shows up in traces.
+func lockWallet(tx *gorm.DB, method string, id uuid.UUID) (_ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(tx.Statement.Context, "lockWallet",
+		trace.WithAttributes(attribute.String("wallet.id", id.String())))
+	defer func() { telemetry.End(span, err) }()
+
+	var w models.Wallet
+
+	start := time.Now()
+	err = tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&w, "id = ?", id).Error
+	wait := time.Since(start)
+	metrics.LockWait.WithLabelValues(method).Observe(wait.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	logging.FromContext(ctx).Debug("Wallet locked", "wallet_id", id, "method", method, "wait", wait)
+
+	return &w, nil
+}
+
+func lockOrder(ids ...uuid.UUID) []uuid.UUID {
+	sorted := slices.Clone(ids)
+	slices.SortFunc(sorted, func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
 	})
-	return result, err
+	return slices.Compact(sorted)
 }
 
-func (r *WalletGORMRepository) AllWallets() (*[]models.Wallet, error) {
+// List returns one page of wallets ordered by filter.SortBy with the ID as a
+// tie-breaker, and the number of wallets matching the filter regardless of
+// pagination.
+func (r *WalletGORMRepository) List(ctx context.Context, filter WalletFilter) ([]models.Wallet, int64, error) {
+	column := string(SortByCreatedAt)
+	if filter.SortBy == SortByBalance {
+		column = string(SortByBalance)
+	}
+
+	query := r.DB.WithContext(ctx).Model(&models.Wallet{})
+
+	if filter.MinBalance != nil {
+		query = query.Where("balance >= ?", *filter.MinBalance)
+	}
+	if filter.MaxBalance != nil {
+		query = query.Where("balance <= ?", *filter.MaxBalance)
+	}
+	if filter.Currency != "" {
+		query = query.Where("currency = ?", filter.Currency)
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.After != nil {
+		query = query.Where("("+column+", id) "+comparison+" (?, ?)", filter.After, filter.AfterID)
+	}
+
 	var wallets []models.Wallet
+	err := query.
+		Order(column + " " + direction).
+		Order("id " + direction).
+		Limit(filter.Limit).
+		Find(&wallets).Error
+	if err != nil {
+		return nil, 0, err
+	}
 
-	result := r.DB.
-		Find(&wallets)
+	return wallets, total, nil
+}
 
-	if result.Error != nil {
-		return nil, result.Error
+func recordTransaction(tx *gorm.DB, w *models.Wallet, balanceBefore int64, record *models.Transaction) error {
+	if record == nil {
+		return nil
+	}
+
+	record.ID = uuid.New()
+	record.WalletID = w.ID
+	record.BalanceBefore = balanceBefore
+	record.BalanceAfter = w.Balance
+	record.HeldAfter = w.Held
+	record.Currency = w.Currency
+
+	err := tx.Create(record).Error
+	if isUniqueViolation(err, idempotencyKeyIndex) {
+		return ErrDuplicateIdempotencyKey
+	}
+
+	return err
+}
+
+// recordTransfer stores record as the debit side of a transfer and a credit
+// record derived from it, each pointing at the other wallet.
+func recordTransfer(tx *gorm.DB, from, to *models.Wallet, fromBefore, toBefore int64, record *models.Transaction) error {
+	credit := &models.Transaction{
+		OperationType:  record.OperationType,
+		Amount:         record.Amount,
+		CounterpartyID: &from.ID,
+	}
+	record.CounterpartyID = &to.ID
+
+	if err := recordTransaction(tx, from, fromBefore, record); err != nil {
+		return err
+	}
+	return recordTransaction(tx, to, toBefore, credit)
+}
+
+// Transactions returns wallet operations newest first, starting after the
+// cursor position when one is set.
+func (r *WalletGORMRepository) Transactions(ctx context.Context, filter TransactionFilter) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+
+	query := r.DB.WithContext(ctx).Where("wallet_id = ?", filter.WalletID)
+
+	if filter.OperationType != "" {
+		query = query.Where("operation_type = ?", filter.OperationType)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.AfterCreatedAt != nil {
+		query = query.Where("(created_at, id) < (?, ?)", *filter.AfterCreatedAt, filter.AfterID)
+	}
+
+	err := query.
+		Order("created_at DESC").
+		Order("id DESC").
+		Limit(filter.Limit).
+		Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+}
+
+// TransactionByIdempotencyKey returns the operation stored under key, or nil
+// when the key has not been used yet.
+func (r *WalletGORMRepository) TransactionByIdempotencyKey(ctx context.Context, key string) (*models.Transaction, error) {
+	var transaction models.Transaction
+
+	err := r.DB.WithContext(ctx).First(&transaction, "idempotency_key = ?", key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return &wallets, nil
+	return &transaction, nil
 }
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/telemetry"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookRepository interface {
+	CreateSubscription(ctx context.Context, sub *models.WebhookSubscription) error
+	GetSubscription(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error)
+	ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	UpdateSubscription(ctx context.Context, sub *models.WebhookSubscription) error
+	DeleteSubscription(ctx context.Context, id uuid.UUID) error
+	SubscriptionsFor(ctx context.Context, eventType string) ([]models.WebhookSubscription, error)
+
+	EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error
+	Deliveries(ctx context.Context, filter DeliveryFilter) ([]models.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, subscriptionID, id uuid.UUID) (*models.WebhookDelivery, error)
+	ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) ([]models.WebhookDelivery, error)
+	SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+}
+
+// DeliveryFilter selects the delivery log of one subscription, newest
+// first. An empty Status selects every status.
+type DeliveryFilter struct {
+	SubscriptionID uuid.UUID
+	Status         enums.DeliveryStatus
+	Limit          int
+}
+
+// WebhookGORMRepository stores webhook subscriptions and their deliveries in
+// Postgres.
+type WebhookGORMRepository struct {
+	DB *gorm.DB
+}
+
+func (r *WebhookGORMRepository) CreateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	return r.DB.WithContext(ctx).Create(sub).Error
+}
+
+func (r *WebhookGORMRepository) GetSubscription(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	var sub models.WebhookSubscription
+
+	err := r.DB.WithContext(ctx).First(&sub, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &sub, nil
+}
+
+func (r *WebhookGORMRepository) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	err := r.DB.WithContext(ctx).Order("created_at, id").Find(&subs).Error
+	return subs, err
+}
+
+// UpdateSubscription stores the URL, event types and active flag of sub.
+// The secret and creation time never change.
+func (r *WebhookGORMRepository) UpdateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	result := r.DB.WithContext(ctx).
+		Model(&models.WebhookSubscription{ID: sub.ID}).
+		Select("url", "event_types", "active", "updated_at").
+		Updates(sub)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// DeleteSubscription removes the subscription together with its delivery
+// log.
+func (r *WebhookGORMRepository) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	result := r.DB.WithContext(ctx).Delete(&models.WebhookSubscription{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// SubscriptionsFor returns the active subscriptions that receive events of
+// eventType.
+func (r *WebhookGORMRepository) SubscriptionsFor(ctx context.Context, eventType string) ([]models.WebhookSubscription, error) {
+	var subs []models.WebhookSubscription
+	// The jsonb ? operator would be taken for a placeholder, so containment
+	// is checked with @> instead.
+	err := r.DB.WithContext(ctx).
+		Where("active AND event_types @> jsonb_build_array(CAST(? AS text))", eventType).
+		Order("created_at, id").
+		Find(&subs).Error
+	return subs, err
+}
+
+// EnqueueDeliveries stores new deliveries. A delivery of an event that its
+// subscription already has is skipped, so an event published again by the
+// outbox relay is not delivered twice.
+func (r *WebhookGORMRepository) EnqueueDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.DB.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&deliveries).Error
+}
+
+func (r *WebhookGORMRepository) Deliveries(ctx context.Context, filter DeliveryFilter) ([]models.WebhookDelivery, error) {
+	query := r.DB.WithContext(ctx).Where("subscription_id = ?", filter.SubscriptionID)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var deliveries []models.WebhookDelivery
+	err := query.Order("created_at DESC, id DESC").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *WebhookGORMRepository) GetDelivery(ctx context.Context, subscriptionID, id uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+
+	err := r.DB.WithContext(ctx).First(&delivery, "id = ? AND subscription_id = ?", id, subscriptionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// claimDeliveriesSQL leases due deliveries by moving their next attempt to
+// the end of the lease. SKIP LOCKED lets dispatchers running side by side
+// claim different deliveries.
+const claimDeliveriesSQL = `
+UPDATE webhook_deliveries
+SET next_attempt_at = @lease_until, updated_at = @now
+WHERE id IN (
+	SELECT id FROM webhook_deliveries
+	WHERE status = @pending AND next_attempt_at <= @now
+	ORDER BY next_attempt_at
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+// ClaimDeliveries returns up to limit pending deliveries that are due at now,
+// oldest first, leased until leaseUntil: no other claim returns them before
+// then. A dispatcher that dies with a claimed delivery leaves it to be
+// claimed again once the lease runs out.
+func (r *WebhookGORMRepository) ClaimDeliveries(ctx context.Context, now, leaseUntil time.Time, limit int) (_ []models.WebhookDelivery, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WebhookGORMRepository.ClaimDeliveries",
+		trace.WithAttributes(attribute.Int("webhook.limit", limit)))
+	defer func() { telemetry.End(span, err) }()
+
+	var deliveries []models.WebhookDelivery
+	err = r.DB.WithContext(ctx).Raw(claimDeliveriesSQL, map[string]any{
+		"lease_until": leaseUntil,
+		"now":         now,
+		"pending":     enums.DeliveryPending,
+		"limit":       limit,
+	}).Scan(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("webhook.claimed", len(deliveries)))
+	return deliveries, nil
+}
+
+// SaveDelivery stores the state of a delivery after an attempt or a retry.
+func (r *WebhookGORMRepository) SaveDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	result := r.DB.WithContext(ctx).
+		Model(&models.WebhookDelivery{ID: delivery.ID}).
+		Select("status", "attempts", "next_attempt_at", "last_status_code", "last_error", "delivered_at", "updated_at").
+		Updates(delivery)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"itk-academy-test/config"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Server wraps http.Server with graceful shutdown and a readiness flag that
+// is true only while the server accepts new traffic.
+type Server struct {
+	httpServer      *http.Server
+	drainDelay      time.Duration
+	shutdownTimeout time.Duration
+	ready           atomic.Bool
+}
+
+func New(handler http.Handler, cfg config.ServerConfig) *Server {
+	return &Server{
+		httpServer: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           handler,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		},
+		drainDelay:      cfg.DrainDelay,
+		shutdownTimeout: cfg.ShutdownTimeout,
+	}
+}
+
+// Ready reports whether the server is serving and not draining.
+func (s *Server) Ready() bool {
+	return s.ready.Load()
+}
+
+// Run serves until ctx is cancelled. It then reports not ready while it keeps
+// serving for the drain delay, so that load balancers polling readiness
+// stop routing to it, and only then stops accepting connections and waits up
+// to the shutdown timeout for in-flight requests to finish.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.httpServer.Serve(listener)
+	}()
+
+	s.ready.Store(true)
+	slog.Info("Server listening", "addr", s.httpServer.Addr)
+
+	select {
+	case err := <-errCh:
+		s.ready.Store(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	s.ready.Store(false)
+	slog.Info("Draining connections", "delay", s.drainDelay)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(s.drainDelay):
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
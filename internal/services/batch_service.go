@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/telemetry"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// BatchItem is one wallet operation of a batch. ToWalletID is the credited
+// wallet of a TRANSFER.
+type BatchItem struct {
+	WalletID       uuid.UUID
+	OperationType  enums.OperationType
+	Amount         int64
+	Currency       enums.Currency
+	ToWalletID     *uuid.UUID
+	IdempotencyKey string
+}
+
+// BatchResult is the outcome of one BatchItem. Wallet is the debited or
+// credited wallet as the item left it.
+type BatchResult struct {
+	Wallet   *models.Wallet
+	Replayed bool
+	Err      error
+}
+
+// Batch applies items in order and returns one result per item.
+//
+// In atomic mode the items run in a single DB transaction: when one of them
+// fails, nothing is stored, the failing item carries its error, every other
+// item carries ErrBatchRolledBack and the error of the failing item is
+// returned as well. Idempotency keys are stored but not replayed; a key that
+// was used before fails the batch with ErrIdempotencyKeyReused.
+//
+// Otherwise every item is applied on its own, with the idempotency
+// guarantees of OperationWithKey and TransferWithKey, and its error is only
+// reported in its result.
+func (s *WalletService) Batch(ctx context.Context, items []BatchItem, atomic bool) (_ []BatchResult, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletService.Batch",
+		trace.WithAttributes(
+			attribute.Int("batch.size", len(items)),
+			attribute.Bool("batch.atomic", atomic),
+		))
+	defer func() { telemetry.End(span, err) }()
+
+	if atomic {
+		return s.batchAtomic(ctx, items)
+	}
+
+	results := make([]BatchResult, len(items))
+	for i, item := range items {
+		results[i].Wallet, results[i].Replayed, results[i].Err = s.batchItem(ctx, item)
+	}
+	return results, nil
+}
+
+func (s *WalletService) batchItem(ctx context.Context, item BatchItem) (*models.Wallet, bool, error) {
+	if item.OperationType != enums.TRANSFER {
+		return s.OperationWithKey(ctx, item.IdempotencyKey, item.WalletID, item.OperationType, item.Amount, item.Currency)
+	}
+	if item.ToWalletID == nil {
+		return nil, false, ErrInvalidOperation
+	}
+	return s.TransferWithKey(ctx, item.IdempotencyKey, item.WalletID, *item.ToWalletID, item.Amount, item.Currency)
+}
+
+func (s *WalletService) batchAtomic(ctx context.Context, items []BatchItem) ([]BatchResult, error) {
+	results := make([]BatchResult, len(items))
+	steps := make([]repository.BatchStep, len(items))
+	for i, item := range items {
+		step, err := batchStep(item, &results[i])
+		if err != nil {
+			observeOperation(ctx, item.OperationType, err, "wallet_id", item.WalletID, "amount", item.Amount)
+			return rollBackBatch(results, i, err)
+		}
+		steps[i] = step
+	}
+
+	err := s.repo.BatchAtomic(ctx, steps)
+
+	var stepErr *repository.BatchStepError
+	if errors.As(err, &stepErr) {
+		err := stepErr.Err
+		if errors.Is(err, repository.ErrDuplicateIdempotencyKey) {
+			err = ErrIdempotencyKeyReused
+		}
+
+		item := items[stepErr.Index]
+		observeOperation(ctx, item.OperationType, err, "wallet_id", item.WalletID, "amount", item.Amount)
+		return rollBackBatch(results, stepErr.Index, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		observeOperation(ctx, item.OperationType, nil, "wallet_id", item.WalletID, "amount", item.Amount)
+	}
+	return results, nil
+}
+
+// batchStep validates item and turns it into a repository step that
+// records the wallet it leaves behind in result.
+func batchStep(item BatchItem, result *BatchResult) (repository.BatchStep, error) {
+	if item.Amount <= 0 {
+		return repository.BatchStep{}, ErrInvalidAmount
+	}
+
+	var key *string
+	if item.IdempotencyKey != "" {
+		key = &item.IdempotencyKey
+	}
+
+	step := repository.BatchStep{
+		WalletID: item.WalletID,
+		Record:   &models.Transaction{OperationType: item.OperationType, Amount: item.Amount, IdempotencyKey: key},
+	}
+
+	switch item.OperationType {
+	case enums.DEPOSIT, enums.WITHDRAW:
+		step.Apply = func(w, _ *models.Wallet) error {
+			if err := applyOperation(w, item.OperationType, item.Amount, item.Currency); err != nil {
+				return err
+			}
+			result.Wallet = snapshot(w)
+			return nil
+		}
+	case enums.TRANSFER:
+		if item.ToWalletID == nil {
+			return repository.BatchStep{}, ErrInvalidOperation
+		}
+		if *item.ToWalletID == item.WalletID {
+			return repository.BatchStep{}, ErrSameWallet
+		}
+
+		step.ToWalletID = item.ToWalletID
+		step.Apply = func(w, to *models.Wallet) error {
+			if err := applyTransfer(w, to, item.Amount, item.Currency); err != nil {
+				return err
+			}
+			result.Wallet = snapshot(w)
+			return nil
+		}
+	default:
+		return repository.BatchStep{}, ErrInvalidOperation
+	}
+
+	return step, nil
+}
+
+func rollBackBatch(results []BatchResult, failed int, err error) ([]BatchResult, error) {
+	for i := range results {
+		results[i] = BatchResult{Err: ErrBatchRolledBack}
+	}
+	results[failed].Err = err
+	return results, err
+}
+
+func snapshot(w *models.Wallet) *models.Wallet {
+	copied := *w
+	return &copied
+}
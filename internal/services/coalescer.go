@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/metrics"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/telemetry"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// coalescer groups concurrent deposits and withdrawals per wallet and
+// applies each group with a single BatchAtomic call, so that a hot wallet
+// takes one row lock and one transaction per group instead of per
+// operation.
+//
+// The first operation for an idle wallet starts a goroutine that waits for
+// window (or until maxSize operations are queued) and then applies the
+// queue in arrival order, maxSize at a time, until it is empty. Operations
+// arriving while a group is being applied form the next group. Every
+// operation keeps its own checks and result: one that fails is left out of
+// its group without affecting the others.
+type coalescer struct {
+	repo    repository.WalletRepository
+	window  time.Duration
+	maxSize int
+
+	mu     sync.Mutex
+	queues map[uuid.UUID]*walletQueue
+}
+
+type walletQueue struct {
+	pending []*coalescedOp
+	full    chan struct{}
+}
+
+type coalescedOp struct {
+	ctx      context.Context
+	op       enums.OperationType
+	amount   int64
+	currency enums.Currency
+
+	wallet *models.Wallet
+	err    error
+	done   chan struct{}
+}
+
+// EnableCoalescing routes Operation calls without an idempotency key through
+// a per-wallet coalescer that applies the deposits and withdrawals arriving
+// within window of each other, up to maxSize at a time, in one transaction.
+// It must be called before the service is used.
+func (s *WalletService) EnableCoalescing(window time.Duration, maxSize int) {
+	s.coalescer = &coalescer{
+		repo:    s.repo,
+		window:  window,
+		maxSize: max(maxSize, 1),
+		queues:  make(map[uuid.UUID]*walletQueue),
+	}
+}
+
+// submit queues the operation and waits for the group it ends up in. The
+// operation is dropped with ctx.Err() if ctx is done by the time its group
+// starts. Once the group has started, submit waits for its transaction to
+// finish even if ctx is done meanwhile, so that an operation that was
+// committed is never reported as failed.
+func (c *coalescer) submit(ctx context.Context, id uuid.UUID, op enums.OperationType, amount int64, currency enums.Currency) (*models.Wallet, error) {
+	o := &coalescedOp{ctx: ctx, op: op, amount: amount, currency: currency, done: make(chan struct{})}
+
+	c.mu.Lock()
+	q, ok := c.queues[id]
+	if !ok {
+		q = &walletQueue{full: make(chan struct{}, 1)}
+		c.queues[id] = q
+		go c.run(id, q)
+	}
+	q.pending = append(q.pending, o)
+	if len(q.pending) >= c.maxSize {
+		select {
+		case q.full <- struct{}{}:
+		default:
+		}
+	}
+	c.mu.Unlock()
+
+	<-o.done
+	return o.wallet, o.err
+}
+
+func (c *coalescer) run(id uuid.UUID, q *walletQueue) {
+	timer := time.NewTimer(c.window)
+	select {
+	case <-timer.C:
+	case <-q.full:
+		timer.Stop()
+	}
+
+	for {
+		c.mu.Lock()
+		n := min(len(q.pending), c.maxSize)
+		if n == 0 {
+			delete(c.queues, id)
+			c.mu.Unlock()
+			return
+		}
+		group := q.pending[:n]
+		q.pending = append([]*coalescedOp(nil), q.pending[n:]...)
+		c.mu.Unlock()
+
+		c.apply(id, group)
+	}
+}
+
+func (c *coalescer) apply(id uuid.UUID, group []*coalescedOp) {
+	live := make([]*coalescedOp, 0, len(group))
+	for _, o := range group {
+		if err := o.ctx.Err(); err != nil {
+			o.err = err
+			close(o.done)
+			continue
+		}
+		live = append(live, o)
+	}
+	if len(live) == 0 {
+		return
+	}
+
+	links := make([]trace.Link, 0, len(live))
+	for _, o := range live {
+		links = append(links, trace.LinkFromContext(o.ctx))
+	}
+
+	// The group outlives the request that happened to open it, so it only
+	// borrows that request's logger and trace.
+	ctx, span := otel.Tracer(tracerName).Start(context.WithoutCancel(live[0].ctx), "WalletService.coalesce",
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("wallet.id", id.String()),
+			attribute.Int("batch.size", len(live)),
+		))
+	metrics.CoalescedGroupSize.Observe(float64(len(live)))
+
+	steps := make([]repository.BatchStep, len(live))
+	for i, o := range live {
+		steps[i] = repository.BatchStep{
+			WalletID: id,
+			Record:   &models.Transaction{OperationType: o.op, Amount: o.amount},
+			Apply: func(w, _ *models.Wallet) error {
+				o.wallet, o.err = nil, applyOperation(w, o.op, o.amount, o.currency)
+				if o.err != nil {
+					return repository.ErrSkipStep
+				}
+				o.wallet = snapshot(w)
+				return nil
+			},
+		}
+	}
+
+	err := c.repo.BatchAtomic(ctx, steps)
+	telemetry.End(span, err)
+
+	var stepErr *repository.BatchStepError
+	if errors.As(err, &stepErr) {
+		err = stepErr.Err
+	}
+	for _, o := range live {
+		if err != nil {
+			o.wallet, o.err = nil, err
+		}
+		close(o.done)
+	}
+}
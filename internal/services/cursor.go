@@ -0,0 +1,38 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// cursor is the opaque keyset position handed to clients: the value of the
+// sort column of the last returned row plus its ID as a tie-breaker. Sort
+// records the ordering the cursor was issued for, so that it is not reused
+// with a different one.
+type cursor struct {
+	Sort  string    `json:"s,omitempty"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"errors"
+	"itk-academy-test/internal/repository"
+)
+
+var (
+	ErrWalletNotFound       = repository.ErrWalletNotFound
+	ErrInsufficientFunds    = repository.ErrInsufficientFunds
+	ErrInvalidOperation     = errors.New("Invalid operation type")
+	ErrInvalidAmount        = errors.New("Amount must be positive")
+	ErrSameWallet           = errors.New("Cannot transfer to the same wallet")
+	ErrIdempotencyKeyReused = errors.New("Idempotency key was already used with a different request")
+	ErrInvalidCursor        = errors.New("Invalid cursor")
+	ErrUnsupportedCurrency  = errors.New("Unsupported currency")
+	ErrCurrencyMismatch     = repository.ErrCurrencyMismatch
+	ErrHoldNotFound         = repository.ErrHoldNotFound
+	ErrHoldNotActive        = errors.New("Hold is no longer active")
+	ErrHoldExpired          = errors.New("Hold has expired")
+	ErrInvalidHoldTTL       = errors.New("Hold TTL is out of range")
+	ErrCaptureExceedsHold   = errors.New("Capture amount exceeds the held amount")
+	ErrConcurrentUpdate     = repository.ErrConcurrentUpdate
+	ErrBatchRolledBack      = errors.New("Batch was rolled back because another operation failed")
+	ErrSubscriptionNotFound = repository.ErrSubscriptionNotFound
+	ErrInvalidEventTypes    = errors.New("Event types must be a non-empty list of known event types")
+	ErrDeliveryNotFound     = repository.ErrDeliveryNotFound
+	ErrDeliveryNotDead      = errors.New("Only dead deliveries can be retried")
+)
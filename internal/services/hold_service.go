@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/telemetry"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errHoldSkipped = errors.New("hold skipped")
+
+const (
+	DefaultHoldTTL = 15 * time.Minute
+	MaxHoldTTL     = 30 * 24 * time.Hour
+)
+
+func (s *WalletService) GetHold(ctx context.Context, id uuid.UUID) (*models.Hold, error) {
+	return s.repo.GetHold(ctx, id)
+}
+
+// CreateHold reserves amount of the wallet's available balance for ttl
+// (DefaultHoldTTL when zero). The balance itself is unchanged until capture.
+func (s *WalletService) CreateHold(ctx context.Context, walletID uuid.UUID, amount int64, currency enums.Currency, ttl time.Duration) (_ *models.Hold, _ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletService.CreateHold",
+		trace.WithAttributes(
+			attribute.String("wallet.id", walletID.String()),
+			attribute.Int64("wallet.amount", amount),
+		))
+	defer func() {
+		observeOperation(ctx, enums.HOLD, err, "wallet_id", walletID, "amount", amount)
+		telemetry.End(span, err)
+	}()
+
+	if amount <= 0 {
+		return nil, nil, ErrInvalidAmount
+	}
+	if ttl == 0 {
+		ttl = DefaultHoldTTL
+	}
+	if ttl < 0 || ttl > MaxHoldTTL {
+		return nil, nil, ErrInvalidHoldTTL
+	}
+
+	hold := &models.Hold{
+		WalletID:  walletID,
+		Amount:    amount,
+		Status:    enums.HoldActive,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	record := &models.Transaction{OperationType: enums.HOLD, Amount: amount}
+
+	wallet, err := s.repo.CreateHold(ctx, hold, record, func(w *models.Wallet) error {
+		if currency != "" && w.Currency != currency {
+			return ErrCurrencyMismatch
+		}
+		if w.Available() < amount {
+			return ErrInsufficientFunds
+		}
+		w.Held += amount
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hold, wallet, nil
+}
+
+// CaptureHold debits amount (the whole hold when zero) from the wallet and
+// releases whatever part of the hold was not captured.
+func (s *WalletService) CaptureHold(ctx context.Context, id uuid.UUID, amount int64) (_ *models.Hold, _ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletService.CaptureHold",
+		trace.WithAttributes(
+			attribute.String("hold.id", id.String()),
+			attribute.Int64("wallet.amount", amount),
+		))
+	defer func() {
+		observeOperation(ctx, enums.CAPTURE, err, "hold_id", id, "amount", amount)
+		telemetry.End(span, err)
+	}()
+
+	if amount < 0 {
+		return nil, nil, ErrInvalidAmount
+	}
+
+	record := &models.Transaction{OperationType: enums.CAPTURE}
+
+	wallet, hold, err := s.repo.OperateHold(ctx, id, record, func(w *models.Wallet, h *models.Hold) error {
+		if err := s.checkActive(h); err != nil {
+			return err
+		}
+
+		captured := amount
+		if captured == 0 {
+			captured = h.Amount
+		}
+		if captured > h.Amount {
+			return ErrCaptureExceedsHold
+		}
+
+		w.Held -= h.Amount
+		w.Balance -= captured
+		h.CapturedAmount = captured
+		h.Status = enums.HoldCaptured
+		record.Amount = captured
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hold, wallet, nil
+}
+
+// ReleaseHold returns the held amount to the available balance.
+func (s *WalletService) ReleaseHold(ctx context.Context, id uuid.UUID) (_ *models.Hold, _ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletService.ReleaseHold",
+		trace.WithAttributes(attribute.String("hold.id", id.String())))
+	defer func() {
+		observeOperation(ctx, enums.RELEASE, err, "hold_id", id)
+		telemetry.End(span, err)
+	}()
+
+	record := &models.Transaction{OperationType: enums.RELEASE}
+
+	wallet, hold, err := s.repo.OperateHold(ctx, id, record, func(w *models.Wallet, h *models.Hold) error {
+		if h.Status != enums.HoldActive {
+			return ErrHoldNotActive
+		}
+
+		w.Held -= h.Amount
+		h.Status = enums.HoldReleased
+		record.Amount = h.Amount
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hold, wallet, nil
+}
+
+// ExpireHolds releases up to limit holds that expired by now and records
+// each release in the wallet history. It returns the number of holds
+// expired; holds captured or released concurrently are skipped.
+func (s *WalletService) ExpireHolds(ctx context.Context, now time.Time, limit int) (_ int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletService.ExpireHolds")
+	defer func() { telemetry.End(span, err) }()
+
+	holds, err := s.repo.ExpiredHolds(ctx, now, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	var errs []error
+	for _, hold := range holds {
+		record := &models.Transaction{OperationType: enums.RELEASE}
+
+		_, _, err := s.repo.OperateHold(ctx, hold.ID, record, func(w *models.Wallet, h *models.Hold) error {
+			if h.Status != enums.HoldActive || now.Before(h.ExpiresAt) {
+				return errHoldSkipped
+			}
+
+			w.Held -= h.Amount
+			h.Status = enums.HoldExpired
+			record.Amount = h.Amount
+			return nil
+		})
+		switch {
+		case err == nil:
+			expired++
+		case !errors.Is(err, errHoldSkipped):
+			errs = append(errs, fmt.Errorf("expire hold %s: %w", hold.ID, err))
+		}
+	}
+
+	return expired, errors.Join(errs...)
+}
+
+func (s *WalletService) checkActive(h *models.Hold) error {
+	if h.Status != enums.HoldActive {
+		return ErrHoldNotActive
+	}
+	if !time.Now().Before(h.ExpiresAt) {
+		return ErrHoldExpired
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+
+	"github.com/google/uuid"
+)
+
+// Reconcile checks that every wallet balance still matches the ledger
+// postings it is a projection of, and that every journal entry balances.
+// Discrepancies are logged; they are not errors of the check itself.
+func (s *WalletService) Reconcile(ctx context.Context) (*repository.ReconciliationReport, error) {
+	report, err := s.repo.Reconcile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.Clean() {
+		logging.FromContext(ctx).Warn("Ledger reconciliation found discrepancies",
+			"wallets", report.Wallets,
+			"mismatches", len(report.Mismatches),
+			"unbalanced_entries", len(report.UnbalancedEntries))
+	}
+	return report, nil
+}
+
+// AdjustLedger books the difference between the wallet and its ledger
+// accounts as an adjustment entry, leaving the wallet balance as it is. It
+// returns nil when the two already agree.
+func (s *WalletService) AdjustLedger(ctx context.Context, walletID uuid.UUID) (*models.JournalEntry, error) {
+	entry, err := s.repo.AdjustLedger(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil {
+		logging.FromContext(ctx).Info("Ledger adjusted to the wallet balance",
+			"wallet_id", walletID, "entry_id", entry.ID)
+	}
+	return entry, nil
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"errors"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/metrics"
+	"itk-academy-test/internal/repository"
+	"log/slog"
+)
+
+// observeOperation counts op under the outcome derived from err and logs it
+// together with attrs, which identify the wallet or hold touched.
+func observeOperation(ctx context.Context, op enums.OperationType, err error, attrs ...any) {
+	result := outcome(err)
+	metrics.Operations.WithLabelValues(string(op), result).Inc()
+
+	level := slog.LevelInfo
+	switch {
+	case result == "error":
+		level = slog.LevelError
+		attrs = append(attrs, "error", err)
+	case err != nil:
+		level = slog.LevelWarn
+	}
+
+	attrs = append(attrs, "operation", op, "outcome", result)
+	logging.FromContext(ctx).Log(ctx, level, "Wallet operation", attrs...)
+}
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrInsufficientFunds):
+		return "insufficient_funds"
+	case errors.Is(err, ErrWalletNotFound), errors.Is(err, ErrHoldNotFound):
+		return "not_found"
+	case errors.Is(err, ErrCurrencyMismatch):
+		return "currency_mismatch"
+	case errors.Is(err, ErrHoldNotActive), errors.Is(err, ErrHoldExpired):
+		return "hold_unavailable"
+	case errors.Is(err, ErrConcurrentUpdate):
+		return "conflict"
+	case errors.Is(err, repository.ErrDuplicateIdempotencyKey):
+		return "duplicate"
+	case errors.Is(err, ErrInvalidAmount), errors.Is(err, ErrInvalidOperation),
+		errors.Is(err, ErrSameWallet), errors.Is(err, ErrInvalidHoldTTL),
+		errors.Is(err, ErrCaptureExceedsHold):
+		return "invalid"
+	default:
+		return "error"
+	}
+}
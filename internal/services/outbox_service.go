@@ -0,0 +1,25 @@
+package services
+
+import (
+	"context"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/metrics"
+	"itk-academy-test/internal/models"
+)
+
+// PublishEvents hands up to limit due domain events to publisher, oldest
+// first, and returns how many it accepted. When publisher rejects an event
+// the run stops there and its error is returned; the event is offered again
+// once its retry backoff has passed.
+func (s *WalletService) PublishEvents(ctx context.Context, publisher events.EventPublisher, limit int) (int, error) {
+	return s.repo.PublishEvents(ctx, limit, func(ctx context.Context, event models.OutboxEvent) error {
+		err := publisher.Publish(ctx, event)
+
+		outcome := "published"
+		if err != nil {
+			outcome = "failed"
+		}
+		metrics.OutboxEvents.WithLabelValues(event.Type, outcome).Inc()
+		return err
+	})
+}
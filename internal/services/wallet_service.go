@@ -1,25 +1,49 @@
 package services
 
 import (
+	"context"
 	"errors"
 	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/logging"
 	"itk-academy-test/internal/models"
 	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/telemetry"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "itk-academy-test/internal/services"
+
+	defaultPageSize = 50
 )
 
 type WalletService struct {
-	repo repository.WalletRepository
+	repo      repository.WalletRepository
+	coalescer *coalescer
 }
 
 func New(r repository.WalletRepository) *WalletService {
 	return &WalletService{repo: r}
 }
 
-func (s *WalletService) Create() (models.Wallet, error) {
+// Create opens a wallet in currency, or in enums.DefaultCurrency when
+// currency is empty.
+func (s *WalletService) Create(ctx context.Context, currency enums.Currency) (models.Wallet, error) {
+	if currency == "" {
+		currency = enums.DefaultCurrency
+	}
+	if !currency.IsSupported() {
+		return models.Wallet{}, ErrUnsupportedCurrency
+	}
 
-	wallet, err := s.repo.Create()
+	wallet, err := s.repo.Create(ctx, currency)
 
 	if err != nil {
 		return wallet, err
@@ -28,13 +52,17 @@ func (s *WalletService) Create() (models.Wallet, error) {
 	return wallet, nil
 }
 
-func (s *WalletService) Delete(id uuid.UUID) error {
-	return s.repo.Delete(id)
+func (s *WalletService) Delete(ctx context.Context, id uuid.UUID) error {
+	return s.repo.Delete(ctx, id)
+}
+
+func (s *WalletService) Get(ctx context.Context, id uuid.UUID) (*models.Wallet, error) {
+	return s.repo.Get(ctx, id)
 }
 
-func (s *WalletService) Amount(id uuid.UUID) (int, error) {
+func (s *WalletService) Amount(ctx context.Context, id uuid.UUID) (int64, error) {
 
-	wallet, err := s.repo.Get(id)
+	wallet, err := s.repo.Get(ctx, id)
 	if err != nil {
 		return 0, err
 	}
@@ -42,27 +70,298 @@ func (s *WalletService) Amount(id uuid.UUID) (int, error) {
 	return wallet.Balance, nil
 }
 
-func (s *WalletService) Operation(id uuid.UUID, op enums.OperationType, amount int) (*models.Wallet, error) {
+// Operation applies op to the wallet. amount is in minor units; currency,
+// when not empty, must match the wallet currency.
+func (s *WalletService) Operation(ctx context.Context, id uuid.UUID, op enums.OperationType, amount int64, currency enums.Currency) (*models.Wallet, error) {
+	return s.operate(ctx, id, op, amount, currency, nil)
+}
+
+// OperationWithKey applies the operation at most once per idempotency key.
+// A replayed key returns the stored result and reports replayed=true without
+// touching the balance; a key reused with a different payload is rejected.
+func (s *WalletService) OperationWithKey(ctx context.Context, key string, id uuid.UUID, op enums.OperationType, amount int64, currency enums.Currency) (*models.Wallet, bool, error) {
+	matches := func(t *models.Transaction) bool {
+		return t.WalletID == id && t.OperationType == op && t.Amount == amount &&
+			(currency == "" || t.Currency == currency)
+	}
+
+	return s.withIdempotencyKey(ctx, key, matches, func(key *string) (*models.Wallet, error) {
+		return s.operate(ctx, id, op, amount, currency, key)
+	})
+}
+
+// Transfer debits from and credits to in a single DB transaction. Both
+// wallets must hold the same currency.
+func (s *WalletService) Transfer(ctx context.Context, from, to uuid.UUID, amount int64, currency enums.Currency) (*models.Wallet, *models.Wallet, error) {
+	return s.transfer(ctx, from, to, amount, currency, nil)
+}
+
+// TransferWithKey is Transfer with the idempotency guarantees of
+// OperationWithKey. It returns the debited wallet.
+func (s *WalletService) TransferWithKey(ctx context.Context, key string, from, to uuid.UUID, amount int64, currency enums.Currency) (*models.Wallet, bool, error) {
+	matches := func(t *models.Transaction) bool {
+		return t.WalletID == from && t.OperationType == enums.TRANSFER && t.Amount == amount &&
+			t.CounterpartyID != nil && *t.CounterpartyID == to &&
+			(currency == "" || t.Currency == currency)
+	}
+
+	return s.withIdempotencyKey(ctx, key, matches, func(key *string) (*models.Wallet, error) {
+		w, _, err := s.transfer(ctx, from, to, amount, currency, key)
+		return w, err
+	})
+}
+
+func (s *WalletService) operate(ctx context.Context, id uuid.UUID, op enums.OperationType, amount int64, currency enums.Currency, key *string) (_ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletService.Operation",
+		trace.WithAttributes(
+			attribute.String("wallet.id", id.String()),
+			attribute.String("wallet.operation", string(op)),
+			attribute.Int64("wallet.amount", amount),
+		))
+	defer func() {
+		observeOperation(ctx, op, err, "wallet_id", id, "amount", amount)
+		telemetry.End(span, err)
+	}()
+
 	if amount <= 0 {
-		return nil, errors.New("Amount must be positive")
-	}
-
-	return s.repo.OperateAtomic(id, func(w *models.Wallet) error {
-		switch op {
-		case enums.DEPOSIT:
-			w.Balance += amount
-		case enums.WITHDRAW:
-			if w.Balance < amount {
-				return errors.New("Insufficient funds")
-			}
-			w.Balance -= amount
-		default:
-			return errors.New("Error")
-		}
-		return nil
+		return nil, ErrInvalidAmount
+	}
+
+	var delta int64
+	switch op {
+	case enums.DEPOSIT:
+		delta = amount
+	case enums.WITHDRAW:
+		delta = -amount
+	default:
+		return nil, ErrInvalidOperation
+	}
+
+	// Keyed operations need their own replay handling and are never
+	// coalesced.
+	if s.coalescer != nil && key == nil {
+		return s.coalescer.submit(ctx, id, op, amount, currency)
+	}
+
+	record := &models.Transaction{OperationType: op, Amount: amount, IdempotencyKey: key}
+
+	// A deposit or withdrawal is a plain balance delta, so it skips the
+	// lock-read-write round trips of OperateAtomic.
+	return s.repo.AdjustBalance(ctx, id, delta, currency, record)
+}
+
+func (s *WalletService) transfer(ctx context.Context, from, to uuid.UUID, amount int64, currency enums.Currency, key *string) (_, _ *models.Wallet, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WalletService.Transfer",
+		trace.WithAttributes(
+			attribute.String("wallet.id", from.String()),
+			attribute.String("wallet.to_id", to.String()),
+			attribute.Int64("wallet.amount", amount),
+		))
+	defer func() {
+		observeOperation(ctx, enums.TRANSFER, err, "wallet_id", from, "to_wallet_id", to, "amount", amount)
+		telemetry.End(span, err)
+	}()
+
+	if amount <= 0 {
+		return nil, nil, ErrInvalidAmount
+	}
+	if from == to {
+		return nil, nil, ErrSameWallet
+	}
+
+	record := &models.Transaction{OperationType: enums.TRANSFER, Amount: amount, IdempotencyKey: key}
+
+	return s.repo.TransferAtomic(ctx, from, to, record, func(fw, tw *models.Wallet) error {
+		return applyTransfer(fw, tw, amount, currency)
 	})
 }
 
-func (s *WalletService) AllWallets() (*[]models.Wallet, error) {
-	return s.repo.AllWallets()
+// applyOperation applies a deposit or withdrawal to a wallet read for a
+// read-modify-write. It mirrors the checks AdjustBalance makes in SQL.
+func applyOperation(w *models.Wallet, op enums.OperationType, amount int64, currency enums.Currency) error {
+	if currency != "" && w.Currency != currency {
+		return ErrCurrencyMismatch
+	}
+
+	switch op {
+	case enums.DEPOSIT:
+		w.Balance += amount
+	case enums.WITHDRAW:
+		if w.Available() < amount {
+			return ErrInsufficientFunds
+		}
+		w.Balance -= amount
+	default:
+		return ErrInvalidOperation
+	}
+	return nil
+}
+
+func applyTransfer(fw, tw *models.Wallet, amount int64, currency enums.Currency) error {
+	if fw.Currency != tw.Currency || (currency != "" && fw.Currency != currency) {
+		return ErrCurrencyMismatch
+	}
+	if fw.Available() < amount {
+		return ErrInsufficientFunds
+	}
+	fw.Balance -= amount
+	tw.Balance += amount
+	return nil
+}
+
+// withIdempotencyKey runs fn at most once per key. matches reports whether a
+// stored operation was made with the same payload as the current request.
+func (s *WalletService) withIdempotencyKey(ctx context.Context, key string, matches func(*models.Transaction) bool, fn func(key *string) (*models.Wallet, error)) (*models.Wallet, bool, error) {
+	if key == "" {
+		wallet, err := fn(nil)
+		return wallet, false, err
+	}
+
+	existing, err := s.repo.TransactionByIdempotencyKey(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if existing == nil {
+		wallet, err := fn(&key)
+		if !errors.Is(err, repository.ErrDuplicateIdempotencyKey) {
+			return wallet, false, err
+		}
+
+		// A concurrent request with the same key committed first.
+		existing, err = s.repo.TransactionByIdempotencyKey(ctx, key)
+		if err != nil {
+			return nil, false, err
+		}
+		if existing == nil {
+			return nil, false, repository.ErrDuplicateIdempotencyKey
+		}
+	}
+
+	if !matches(existing) {
+		return nil, false, ErrIdempotencyKeyReused
+	}
+
+	logging.FromContext(ctx).Info("Idempotent replay",
+		"wallet_id", existing.WalletID, "operation", existing.OperationType, "transaction_id", existing.ID)
+
+	replayed := &models.Wallet{
+		ID:       existing.WalletID,
+		Balance:  existing.BalanceAfter,
+		Held:     existing.HeldAfter,
+		Currency: existing.Currency,
+	}
+	return replayed, true, nil
+}
+
+// List returns one page of wallets, the cursor of the next page ("" on the
+// last page) and the number of wallets matching the filters.
+func (s *WalletService) List(ctx context.Context, query dto.WalletListQuery) ([]models.Wallet, string, int64, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	filter := repository.WalletFilter{
+		SortBy:     repository.SortByCreatedAt,
+		Desc:       query.Order != "asc",
+		MinBalance: query.MinBalance,
+		MaxBalance: query.MaxBalance,
+		Currency:   enums.Currency(query.Currency),
+		Limit:      limit + 1,
+	}
+	if query.SortBy == "balance" {
+		filter.SortBy = repository.SortByBalance
+	}
+
+	sort := string(filter.SortBy) + ":" + strconv.FormatBool(filter.Desc)
+
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		if c.Sort != sort {
+			return nil, "", 0, ErrInvalidCursor
+		}
+
+		if filter.SortBy == repository.SortByBalance {
+			filter.After, err = strconv.ParseInt(c.Value, 10, 64)
+		} else {
+			filter.After, err = time.Parse(time.RFC3339Nano, c.Value)
+		}
+		if err != nil {
+			return nil, "", 0, ErrInvalidCursor
+		}
+		filter.AfterID = c.ID
+	}
+
+	wallets, total, err := s.repo.List(ctx, filter)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	nextCursor := ""
+	if len(wallets) > limit {
+		wallets = wallets[:limit]
+		last := wallets[limit-1]
+
+		value := last.CreatedAt.Format(time.RFC3339Nano)
+		if filter.SortBy == repository.SortByBalance {
+			value = strconv.FormatInt(last.Balance, 10)
+		}
+		nextCursor = encodeCursor(cursor{Sort: sort, Value: value, ID: last.ID})
+	}
+
+	return wallets, nextCursor, total, nil
+}
+
+// Transactions returns one page of the wallet operation history, newest
+// first, together with the cursor of the next page ("" on the last page).
+func (s *WalletService) Transactions(ctx context.Context, id uuid.UUID, query dto.TransactionHistoryQuery) ([]models.Transaction, string, error) {
+	if _, err := s.repo.Get(ctx, id); err != nil {
+		return nil, "", err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+
+	filter := repository.TransactionFilter{
+		WalletID:      id,
+		OperationType: enums.OperationType(query.OperationType),
+		From:          query.From,
+		To:            query.To,
+		Limit:         limit + 1,
+	}
+
+	if query.Cursor != "" {
+		c, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+
+		createdAt, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+
+		filter.AfterCreatedAt = &createdAt
+		filter.AfterID = c.ID
+	}
+
+	transactions, err := s.repo.Transactions(ctx, filter)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(transactions) > limit {
+		transactions = transactions[:limit]
+		last := transactions[limit-1]
+		nextCursor = encodeCursor(cursor{Value: last.CreatedAt.Format(time.RFC3339Nano), ID: last.ID})
+	}
+
+	return transactions, nextCursor, nil
 }
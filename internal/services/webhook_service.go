@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/metrics"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/telemetry"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// deliveryLeaseMargin is added to the client timeout to lease a claimed
+	// delivery for long enough to record the outcome of its attempt.
+	deliveryLeaseMargin = 30 * time.Second
+
+	secretBytes = 32
+)
+
+var errSubscriptionInactive = errors.New("Subscription is inactive")
+
+// WebhookRetryPolicy bounds the attempts of a webhook delivery. After failed
+// attempt n the next one is made BaseDelay * 2^(n-1) later, capped at
+// MaxDelay. A delivery whose MaxAttempts attempts all failed is dead.
+type WebhookRetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultWebhookRetryPolicy = WebhookRetryPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   10 * time.Second,
+	MaxDelay:    time.Hour,
+}
+
+func (p WebhookRetryPolicy) withDefaults() WebhookRetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultWebhookRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultWebhookRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay < p.BaseDelay {
+		p.MaxDelay = max(DefaultWebhookRetryPolicy.MaxDelay, p.BaseDelay)
+	}
+	return p
+}
+
+// Backoff returns the delay before the attempt that follows failed attempt
+// number attempt.
+func (p WebhookRetryPolicy) Backoff(attempt int) time.Duration {
+	if shift := max(attempt-1, 0); shift < 32 && p.BaseDelay<<shift < p.MaxDelay {
+		return p.BaseDelay << shift
+	}
+	return p.MaxDelay
+}
+
+// WebhookService manages webhook subscriptions and delivers the domain
+// events they ask for. It is an events.EventPublisher: Publish queues one
+// delivery per interested subscription, and DispatchDeliveries sends the
+// deliveries that are due.
+type WebhookService struct {
+	repo   repository.WebhookRepository
+	client *http.Client
+	retry  WebhookRetryPolicy
+}
+
+// NewWebhookService returns a service that sends deliveries with client, or
+// with an events.NewPublicClient timing out after
+// events.DefaultWebhookTimeout when client is nil. Subscription URLs come
+// from API callers, so client should refuse internal destinations the same
+// way. A client without a timeout is given the default one: the lease of a
+// delivery being sent is derived from it, and an unbounded attempt could
+// outlive its lease and be sent twice. Unset fields of retry take their
+// DefaultWebhookRetryPolicy value.
+func NewWebhookService(r repository.WebhookRepository, client *http.Client, retry WebhookRetryPolicy) *WebhookService {
+	if client == nil {
+		client = events.NewPublicClient(events.DefaultWebhookTimeout)
+	}
+	if client.Timeout <= 0 {
+		bounded := *client
+		bounded.Timeout = events.DefaultWebhookTimeout
+		client = &bounded
+	}
+	return &WebhookService{repo: r, client: client, retry: retry.withDefaults()}
+}
+
+// CreateSubscription registers url for the events of eventTypes and
+// generates the secret its deliveries are signed with.
+func (s *WebhookService) CreateSubscription(ctx context.Context, url string, eventTypes []string) (*models.WebhookSubscription, error) {
+	types, err := validEventTypes(eventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sub := &models.WebhookSubscription{
+		ID:         uuid.New(),
+		URL:        url,
+		Secret:     hex.EncodeToString(secret),
+		EventTypes: types,
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.repo.CreateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *WebhookService) GetSubscription(ctx context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	return s.repo.GetSubscription(ctx, id)
+}
+
+func (s *WebhookService) ListSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return s.repo.ListSubscriptions(ctx)
+}
+
+// UpdateSubscription replaces the URL, event types and active flag of a
+// subscription. Deliveries already queued keep the events they carry.
+func (s *WebhookService) UpdateSubscription(ctx context.Context, id uuid.UUID, url string, eventTypes []string, active bool) (*models.WebhookSubscription, error) {
+	types, err := validEventTypes(eventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := s.repo.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	sub.URL = url
+	sub.EventTypes = types
+	sub.Active = active
+	sub.UpdatedAt = time.Now()
+	if err := s.repo.UpdateSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// DeleteSubscription removes a subscription and its delivery log, including
+// the deliveries not made yet.
+func (s *WebhookService) DeleteSubscription(ctx context.Context, id uuid.UUID) error {
+	return s.repo.DeleteSubscription(ctx, id)
+}
+
+// validEventTypes returns eventTypes without duplicates, or
+// ErrInvalidEventTypes when it is empty or names an unknown type.
+func validEventTypes(eventTypes []string) (models.EventTypes, error) {
+	if len(eventTypes) == 0 {
+		return nil, ErrInvalidEventTypes
+	}
+
+	types := make(models.EventTypes, 0, len(eventTypes))
+	for _, t := range eventTypes {
+		if !slices.Contains(models.WalletEventTypes, t) {
+			return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidEventTypes, t)
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// Deliveries returns the delivery log of a subscription, newest first,
+// limited to the deliveries in status unless it is empty.
+func (s *WebhookService) Deliveries(ctx context.Context, subscriptionID uuid.UUID, status enums.DeliveryStatus, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.repo.GetSubscription(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return s.repo.Deliveries(ctx, repository.DeliveryFilter{
+		SubscriptionID: subscriptionID,
+		Status:         status,
+		Limit:          limit,
+	})
+}
+
+// RetryDelivery takes a dead delivery out of the dead-letter queue and
+// makes it due now with a fresh set of attempts.
+func (s *WebhookService) RetryDelivery(ctx context.Context, subscriptionID, id uuid.UUID) (*models.WebhookDelivery, error) {
+	delivery, err := s.repo.GetDelivery(ctx, subscriptionID, id)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.Status != enums.DeliveryDead {
+		return nil, ErrDeliveryNotDead
+	}
+
+	now := time.Now()
+	delivery.Status = enums.DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.UpdatedAt = now
+	if err := s.repo.SaveDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Publish queues a delivery of event to every active subscription that
+// wants its type. The request body of the deliveries is the event as the
+// other publishers write it. Publishing an event again queues nothing new.
+func (s *WebhookService) Publish(ctx context.Context, event models.OutboxEvent) error {
+	subs, err := s.repo.SubscriptionsFor(ctx, event.Type)
+	if err != nil || len(subs) == 0 {
+		return err
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, len(subs))
+	for i, sub := range subs {
+		deliveries[i] = models.WebhookDelivery{
+			ID:             uuid.New(),
+			SubscriptionID: sub.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Body:           body,
+			Status:         enums.DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		}
+	}
+	return s.repo.EnqueueDeliveries(ctx, deliveries)
+}
+
+// DispatchDeliveries claims up to limit due deliveries and makes one attempt
+// at each, concurrently, and returns how many it attempted. A delivery that
+// fails is due again after the backoff of the retry policy, or dead once it
+// has used up its attempts. The deliveries of an inactive subscription are
+// dead without an attempt.
+func (s *WebhookService) DispatchDeliveries(ctx context.Context, limit int) (_ int, err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "WebhookService.DispatchDeliveries",
+		trace.WithAttributes(attribute.Int("webhook.limit", limit)))
+	defer func() { telemetry.End(span, err) }()
+
+	now := time.Now()
+	deliveries, err := s.repo.ClaimDeliveries(ctx, now, now.Add(s.client.Timeout+deliveryLeaseMargin), limit)
+	if err != nil {
+		return 0, err
+	}
+
+	subs := make(map[uuid.UUID]*models.WebhookSubscription)
+	for _, d := range deliveries {
+		if _, ok := subs[d.SubscriptionID]; ok {
+			continue
+		}
+		sub, err := s.repo.GetSubscription(ctx, d.SubscriptionID)
+		if err != nil && !errors.Is(err, ErrSubscriptionNotFound) {
+			return 0, err
+		}
+		// A subscription deleted since the claim took its deliveries
+		// with it; they are skipped.
+		subs[d.SubscriptionID] = sub
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(deliveries))
+	for i := range deliveries {
+		sub := subs[deliveries[i].SubscriptionID]
+		if sub == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.deliver(ctx, sub, &deliveries[i])
+		}()
+	}
+	wg.Wait()
+
+	return len(deliveries), errors.Join(errs...)
+}
+
+// deliver makes one attempt at delivery and records its outcome. An attempt
+// cut short by ctx is not recorded; the delivery is claimed again once its
+// lease runs out.
+func (s *WebhookService) deliver(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery) error {
+	sendErr := errSubscriptionInactive
+	if sub.Active {
+		var statusCode *int
+		statusCode, sendErr = s.send(ctx, sub, delivery)
+		if ctx.Err() != nil {
+			return nil
+		}
+		delivery.Attempts++
+		delivery.LastStatusCode = statusCode
+	}
+
+	now := time.Now()
+	delivery.UpdatedAt = now
+
+	outcome := "delivered"
+	switch {
+	case sendErr == nil:
+		delivery.Status = enums.DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = nil
+	case sub.Active && delivery.Attempts < s.retry.MaxAttempts:
+		outcome = "retry"
+		delivery.NextAttemptAt = now.Add(s.retry.Backoff(delivery.Attempts))
+		delivery.LastError = errorText(sendErr)
+	default:
+		outcome = "dead"
+		delivery.Status = enums.DeliveryDead
+		delivery.LastError = errorText(sendErr)
+		logging.FromContext(ctx).Warn("Webhook delivery is dead",
+			"delivery_id", delivery.ID, "subscription_id", sub.ID, "event_id", delivery.EventID,
+			"attempts", delivery.Attempts, "error", sendErr)
+	}
+	metrics.WebhookDeliveries.WithLabelValues(delivery.EventType, outcome).Inc()
+
+	return s.repo.SaveDelivery(ctx, delivery)
+}
+
+// send POSTs the body of delivery to the subscription URL, signed with its
+// secret, and returns the response status code, if there was a response.
+// Any status other than 2xx is an error.
+func (s *WebhookService) send(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery) (*int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", delivery.EventID.String())
+	req.Header.Set("X-Event-Type", delivery.EventType)
+	req.Header.Set(events.WebhookIDHeader, delivery.ID.String())
+	req.Header.Set(events.WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(events.WebhookSignatureHeader, events.Sign(sub.Secret, timestamp, delivery.Body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return &resp.StatusCode, nil
+}
+
+func errorText(err error) *string {
+	text := err.Error()
+	return &text
+}
@@ -0,0 +1,152 @@
+// Package streaming fans the committed changes of wallets out to the
+// clients streaming them. Changes reach every backend instance through
+// Postgres LISTEN/NOTIFY; see Listener.
+package streaming
+
+import (
+	enums "itk-academy-test/internal"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// subscriptionBuffer is the number of changes a subscription holds for a
+// slow reader before the oldest is dropped.
+const subscriptionBuffer = 16
+
+// WalletChange is the state of a wallet after a committed change, as
+// announced by the wallets table. Version orders the changes of a wallet.
+// Resync, set by the hub rather than the database, means that changes may
+// have been missed and the wallet should be read again.
+type WalletChange struct {
+	WalletID uuid.UUID      `json:"walletId"`
+	Balance  int64          `json:"balance"`
+	Held     int64          `json:"held"`
+	Currency enums.Currency `json:"currency"`
+	Version  int64          `json:"version"`
+	Deleted  bool           `json:"deleted,omitempty"`
+	Resync   bool           `json:"-"`
+}
+
+// Hub hands every change it is given to the subscriptions of its wallet.
+// Publishing never blocks: a subscription that falls behind loses its
+// oldest changes, which is harmless because every change carries the whole
+// state of the wallet.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[uuid.UUID]map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[uuid.UUID]map[*Subscription]struct{})}
+}
+
+// Subscription receives the changes of one wallet on C until it is closed,
+// or until the hub is closed, which closes C.
+type Subscription struct {
+	C <-chan WalletChange
+
+	ch       chan WalletChange
+	hub      *Hub
+	walletID uuid.UUID
+}
+
+// Subscribe starts receiving the changes of walletID. The subscription of a
+// closed hub is closed already.
+func (h *Hub) Subscribe(walletID uuid.UUID) *Subscription {
+	ch := make(chan WalletChange, subscriptionBuffer)
+	sub := &Subscription{C: ch, ch: ch, hub: h, walletID: walletID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(ch)
+		return sub
+	}
+	if h.subs[walletID] == nil {
+		h.subs[walletID] = make(map[*Subscription]struct{})
+	}
+	h.subs[walletID][sub] = struct{}{}
+	return sub
+}
+
+// Close stops the subscription and closes C. It may be called more than
+// once.
+func (s *Subscription) Close() {
+	h := s.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs, ok := h.subs[s.walletID]
+	if _, subscribed := subs[s]; !ok || !subscribed {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(h.subs, s.walletID)
+	}
+	close(s.ch)
+}
+
+// Publish hands change to the subscriptions of its wallet.
+func (h *Hub) Publish(change WalletChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs[change.WalletID] {
+		sub.send(change)
+	}
+}
+
+// Resync tells every subscription that changes may have been missed, e.g.
+// while the connection to the database was down.
+func (h *Hub) Resync() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for walletID, subs := range h.subs {
+		for sub := range subs {
+			sub.send(WalletChange{WalletID: walletID, Resync: true})
+		}
+	}
+}
+
+// Subscribers returns the number of open subscriptions to walletID.
+func (h *Hub) Subscribers(walletID uuid.UUID) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[walletID])
+}
+
+// Close closes every subscription, ending the streams that read them, and
+// makes later subscriptions start closed.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, subs := range h.subs {
+		for sub := range subs {
+			close(sub.ch)
+		}
+	}
+	h.subs = make(map[uuid.UUID]map[*Subscription]struct{})
+	h.closed = true
+}
+
+// send queues change, dropping the oldest queued change when the buffer is
+// full. It is called with the hub locked, the only sender.
+func (s *Subscription) send(change WalletChange) {
+	for {
+		select {
+		case s.ch <- change:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
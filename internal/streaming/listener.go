@@ -0,0 +1,100 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"itk-academy-test/internal/logging"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Channel is the notification channel that the wallets table announces its
+// committed changes on.
+const Channel = "wallet_changes"
+
+// NotifySetting is the session setting that makes the wallets table
+// announce the changes made in the session. It is off unless turned on.
+const NotifySetting = "wallet.notify_changes"
+
+// NotifyingDSN returns dsn, a keyword/value connection string, with
+// NotifySetting turned on for every session opened with it.
+func NotifyingDSN(dsn string) string {
+	return dsn + " options='-c " + NotifySetting + "=on'"
+}
+
+// DefaultRetryDelay is the pause before reconnecting when Listener.RetryDelay
+// is not set.
+const DefaultRetryDelay = time.Second
+
+// Listener LISTENs on Channel over a connection of its own and publishes
+// every change announced on it to Hub. Every backend instance runs one, so
+// a stream sees the changes committed through any instance.
+type Listener struct {
+	DSN        string
+	Hub        *Hub
+	RetryDelay time.Duration
+}
+
+func NewListener(dsn string, hub *Hub) *Listener {
+	return &Listener{DSN: dsn, Hub: hub, RetryDelay: DefaultRetryDelay}
+}
+
+// Run listens until ctx is cancelled, reconnecting after RetryDelay when the
+// connection fails. Notifications sent while it was disconnected are lost,
+// so the hub is told to resync after every reconnection.
+func (l *Listener) Run(ctx context.Context) {
+	delay := l.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	for connected := false; ; {
+		err := l.listen(ctx, func() {
+			if connected {
+				l.Hub.Resync()
+			}
+			connected = true
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logging.FromContext(ctx).Error("Wallet change listener disconnected", "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// listen connects, calls listening once the LISTEN is in place and then
+// publishes notifications until the connection fails or ctx is cancelled.
+func (l *Listener) listen(ctx context.Context, listening func()) error {
+	conn, err := pgx.Connect(ctx, l.DSN)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+Channel); err != nil {
+		return err
+	}
+	listening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var change WalletChange
+		if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+			logging.FromContext(ctx).Warn("Invalid wallet change notification",
+				"payload", notification.Payload, "error", err)
+			continue
+		}
+		l.Hub.Publish(change)
+	}
+}
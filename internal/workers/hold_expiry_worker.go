@@ -0,0 +1,53 @@
+package workers
+
+import (
+	"context"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/services"
+	"time"
+)
+
+// HoldExpiryWorker periodically releases holds that were neither captured
+// nor released before their expiry.
+type HoldExpiryWorker struct {
+	Service   *services.WalletService
+	Interval  time.Duration
+	BatchSize int
+}
+
+func NewHoldExpiryWorker(s *services.WalletService, interval time.Duration, batchSize int) *HoldExpiryWorker {
+	return &HoldExpiryWorker{Service: s, Interval: interval, BatchSize: batchSize}
+}
+
+// Run sweeps expired holds every Interval until ctx is cancelled. Cancelling
+// ctx also aborts a sweep in progress; holds it did not reach are expired by
+// the next sweep.
+func (w *HoldExpiryWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.sweep(ctx)
+		}
+	}
+}
+
+func (w *HoldExpiryWorker) sweep(ctx context.Context) {
+	for ctx.Err() == nil {
+		expired, err := w.Service.ExpireHolds(ctx, time.Now(), w.BatchSize)
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to expire holds", "error", err)
+			return
+		}
+		if expired > 0 {
+			logging.FromContext(ctx).Info("Expired holds", "count", expired)
+		}
+		if expired < w.BatchSize {
+			return
+		}
+	}
+}
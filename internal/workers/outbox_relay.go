@@ -0,0 +1,55 @@
+package workers
+
+import (
+	"context"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/services"
+	"time"
+)
+
+// OutboxRelay periodically publishes the domain events waiting in the
+// outbox. Several relays may run against the same database; each claims
+// different events.
+type OutboxRelay struct {
+	Service   *services.WalletService
+	Publisher events.EventPublisher
+	Interval  time.Duration
+	BatchSize int
+}
+
+func NewOutboxRelay(s *services.WalletService, publisher events.EventPublisher, interval time.Duration, batchSize int) *OutboxRelay {
+	return &OutboxRelay{Service: s, Publisher: publisher, Interval: interval, BatchSize: batchSize}
+}
+
+// Run drains the outbox every Interval until ctx is cancelled. Events still
+// pending at shutdown are published by the next start.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.relay(ctx)
+		}
+	}
+}
+
+func (r *OutboxRelay) relay(ctx context.Context) {
+	for ctx.Err() == nil {
+		published, err := r.Service.PublishEvents(ctx, r.Publisher, r.BatchSize)
+		if published > 0 {
+			logging.FromContext(ctx).Debug("Published outbox events", "count", published)
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to publish outbox events", "error", err)
+			return
+		}
+		if published < r.BatchSize {
+			return
+		}
+	}
+}
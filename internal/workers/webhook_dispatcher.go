@@ -0,0 +1,52 @@
+package workers
+
+import (
+	"context"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/services"
+	"time"
+)
+
+// WebhookDispatcher periodically sends the webhook deliveries that are due.
+// Several dispatchers may run against the same database.
+type WebhookDispatcher struct {
+	Service   *services.WebhookService
+	Interval  time.Duration
+	BatchSize int
+}
+
+func NewWebhookDispatcher(s *services.WebhookService, interval time.Duration, batchSize int) *WebhookDispatcher {
+	return &WebhookDispatcher{Service: s, Interval: interval, BatchSize: batchSize}
+}
+
+// Run dispatches the due deliveries every Interval until ctx is cancelled.
+// Attempts cut short by the shutdown are made again after the next start.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatch(ctx)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		attempted, err := d.Service.DispatchDeliveries(ctx, d.BatchSize)
+		if attempted > 0 {
+			logging.FromContext(ctx).Debug("Dispatched webhook deliveries", "count", attempted)
+		}
+		if err != nil {
+			logging.FromContext(ctx).Error("Failed to dispatch webhook deliveries", "error", err)
+			return
+		}
+		if attempted < d.BatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/services"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// The benchmarks replay the workloads of the concurrent tests against both
+// concurrency modes, and deposits and withdrawals also against the
+// single-statement path. Run them with e.g.
+//
+//	go test ./tests -run '^$' -bench . -cpu 1,8,32
+//
+// "conflicts/op" is the share of operations that gave up after the
+// optimistic retries; it is always 0 in pessimistic mode and on the
+// single-statement path.
+
+var concurrencyModes = []repository.ConcurrencyMode{
+	repository.ConcurrencyPessimistic,
+	repository.ConcurrencyOptimistic,
+}
+
+// balancePath is one way a deposit or withdrawal reaches the wallet row:
+// the read-modify-write of OperateAtomic in one of the concurrency modes, or
+// the single UPDATE of AdjustBalance that the service uses.
+type balancePath struct {
+	name   string
+	mode   repository.ConcurrencyMode
+	single bool
+}
+
+var balancePaths = []balancePath{
+	{name: "pessimistic", mode: repository.ConcurrencyPessimistic},
+	{name: "optimistic", mode: repository.ConcurrencyOptimistic},
+	{name: "single_statement", mode: repository.ConcurrencyPessimistic, single: true},
+}
+
+// adjust moves the balance of id by delta through path.
+func adjust(repo *repository.WalletGORMRepository, path balancePath, id uuid.UUID, delta int64) error {
+	record := &models.Transaction{OperationType: enums.DEPOSIT, Amount: delta}
+	if delta < 0 {
+		record = &models.Transaction{OperationType: enums.WITHDRAW, Amount: -delta}
+	}
+
+	if path.single {
+		_, err := repo.AdjustBalance(context.Background(), id, delta, enums.RUB, record)
+		return err
+	}
+
+	_, err := repo.OperateAtomic(context.Background(), id, record, func(w *models.Wallet) error {
+		if w.Available()+delta < 0 {
+			return repository.ErrInsufficientFunds
+		}
+		w.Balance += delta
+		return nil
+	})
+	return err
+}
+
+func newBenchService(b *testing.B, mode repository.ConcurrencyMode) (*repository.WalletGORMRepository, *services.WalletService) {
+	b.Helper()
+	repo := &repository.WalletGORMRepository{DB: newDB(b), Concurrency: mode}
+	return repo, services.New(repo)
+}
+
+func createWallets(b *testing.B, repo *repository.WalletGORMRepository, svc *services.WalletService, n int, balance int64) []uuid.UUID {
+	b.Helper()
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		w, err := repo.Create(context.Background(), enums.RUB)
+		require.NoError(b, err)
+		if balance > 0 {
+			_, err = svc.Operation(context.Background(), w.ID, enums.DEPOSIT, balance, enums.RUB)
+			require.NoError(b, err)
+		}
+		ids[i] = w.ID
+	}
+	return ids
+}
+
+// runParallel runs op from b.RunParallel goroutines and reports the share
+// of operations rejected with ErrConcurrentUpdate. Any other error fails the
+// benchmark.
+func runParallel(b *testing.B, op func(i int64) error) {
+	b.Helper()
+	var counter, conflicts atomic.Int64
+	var failure atomic.Value
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			err := op(counter.Add(1))
+			switch {
+			case err == nil:
+			case errors.Is(err, services.ErrConcurrentUpdate):
+				conflicts.Add(1)
+			default:
+				failure.CompareAndSwap(nil, err)
+			}
+		}
+	})
+	b.StopTimer()
+
+	if err, _ := failure.Load().(error); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(conflicts.Load())/float64(b.N), "conflicts/op")
+}
+
+// BenchmarkDeposit_HotWallet is the TestConcurrent_Deposit_1000 workload:
+// every goroutine deposits into the same wallet.
+func BenchmarkDeposit_HotWallet(b *testing.B) {
+	for _, path := range balancePaths {
+		b.Run(path.name, func(b *testing.B) {
+			repo, svc := newBenchService(b, path.mode)
+			id := createWallets(b, repo, svc, 1, 0)[0]
+
+			runParallel(b, func(int64) error {
+				return adjust(repo, path, id, 1)
+			})
+		})
+	}
+}
+
+// BenchmarkDeposit_Spread deposits into 64 wallets, so that writers rarely
+// collide and the cost of the strategy itself dominates.
+func BenchmarkDeposit_Spread(b *testing.B) {
+	for _, path := range balancePaths {
+		b.Run(path.name, func(b *testing.B) {
+			repo, svc := newBenchService(b, path.mode)
+			ids := createWallets(b, repo, svc, 64, 0)
+
+			runParallel(b, func(i int64) error {
+				return adjust(repo, path, ids[i%int64(len(ids))], 1)
+			})
+		})
+	}
+}
+
+// BenchmarkWithdraw_HotWallet is the TestConcurrent_Withdraw_Exact workload
+// on a wallet that never runs dry.
+func BenchmarkWithdraw_HotWallet(b *testing.B) {
+	for _, path := range balancePaths {
+		b.Run(path.name, func(b *testing.B) {
+			repo, svc := newBenchService(b, path.mode)
+			id := createWallets(b, repo, svc, 1, 1_000_000_000)[0]
+
+			runParallel(b, func(int64) error {
+				return adjust(repo, path, id, -1)
+			})
+		})
+	}
+}
+
+// BenchmarkDeposit_HotWallet_Coalesced runs the hot wallet deposits through
+// the service with write coalescing off and with a few windows.
+func BenchmarkDeposit_HotWallet_Coalesced(b *testing.B) {
+	for _, window := range []time.Duration{0, time.Millisecond, 5 * time.Millisecond} {
+		b.Run("window="+window.String(), func(b *testing.B) {
+			repo, svc := newBenchService(b, repository.ConcurrencyPessimistic)
+			if window > 0 {
+				svc.EnableCoalescing(window, 500)
+			}
+			id := createWallets(b, repo, svc, 1, 0)[0]
+
+			runParallel(b, func(int64) error {
+				_, err := svc.Operation(context.Background(), id, enums.DEPOSIT, 1, enums.RUB)
+				return err
+			})
+		})
+	}
+}
+
+// BenchmarkTransfer_Opposite is the TestConcurrent_Transfer_Opposite
+// workload: transfers in both directions between the same two wallets.
+func BenchmarkTransfer_Opposite(b *testing.B) {
+	for _, mode := range concurrencyModes {
+		b.Run(string(mode), func(b *testing.B) {
+			repo, svc := newBenchService(b, mode)
+			ids := createWallets(b, repo, svc, 2, 1_000_000_000)
+
+			runParallel(b, func(i int64) error {
+				from, to := ids[0], ids[1]
+				if i%2 == 1 {
+					from, to = to, from
+				}
+				_, _, err := svc.Transfer(context.Background(), from, to, 1, enums.RUB)
+				return err
+			})
+		})
+	}
+}
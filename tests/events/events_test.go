@@ -0,0 +1,166 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEvent(t *testing.T) models.OutboxEvent {
+	t.Helper()
+	walletID := uuid.New()
+	payload, err := json.Marshal(models.WalletEvent{WalletID: walletID, Currency: enums.RUB, Amount: 100, Balance: 100})
+	require.NoError(t, err)
+
+	return models.OutboxEvent{
+		ID:        uuid.New(),
+		Type:      models.EventWalletCredited,
+		WalletID:  walletID,
+		Payload:   payload,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+		Attempts:  2,
+	}
+}
+
+func TestStdoutPublisher_WritesJSONLines(t *testing.T) {
+	var out bytes.Buffer
+	publisher := &events.StdoutPublisher{Out: &out}
+
+	first, second := newEvent(t), newEvent(t)
+	require.NoError(t, publisher.Publish(context.Background(), first))
+	require.NoError(t, publisher.Publish(context.Background(), second))
+
+	dec := json.NewDecoder(&out)
+	for _, want := range []models.OutboxEvent{first, second} {
+		var got map[string]any
+		require.NoError(t, dec.Decode(&got))
+		assert.Equal(t, want.ID.String(), got["id"])
+		assert.Equal(t, want.Type, got["type"])
+		assert.Equal(t, want.WalletID.String(), got["walletId"])
+		assert.Equal(t, float64(100), got["payload"].(map[string]any)["amount"])
+		assert.NotContains(t, got, "attempts", "delivery bookkeeping is not part of the event")
+	}
+}
+
+func TestWebhookPublisher_Delivers(t *testing.T) {
+	var received models.OutboxEvent
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	event := newEvent(t)
+	publisher := events.NewWebhookPublisher(srv.URL, time.Second)
+	require.NoError(t, publisher.Publish(context.Background(), event))
+
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, event.ID.String(), header.Get("X-Event-ID"))
+	assert.Equal(t, event.Type, header.Get("X-Event-Type"))
+	assert.Equal(t, event.ID, received.ID)
+	assert.JSONEq(t, string(event.Payload), string(received.Payload))
+}
+
+func TestWebhookPublisher_FailsOnErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	publisher := events.NewWebhookPublisher(srv.URL, time.Second)
+	err := publisher.Publish(context.Background(), newEvent(t))
+	assert.ErrorContains(t, err, "503")
+
+	srv.Close()
+	assert.Error(t, publisher.Publish(context.Background(), newEvent(t)))
+}
+
+func TestMemoryPublisher(t *testing.T) {
+	publisher := &events.MemoryPublisher{}
+	event := newEvent(t)
+
+	publisher.FailWith(errors.New("unavailable"))
+	assert.EqualError(t, publisher.Publish(context.Background(), event), "unavailable")
+	assert.Empty(t, publisher.Events())
+
+	publisher.FailWith(nil)
+	assert.NoError(t, publisher.Publish(context.Background(), event))
+	assert.NoError(t, publisher.Publish(context.Background(), event))
+	assert.Equal(t, []models.OutboxEvent{event, event}, publisher.Events())
+}
+
+func TestSign_Verify(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	signature := events.Sign("secret", 1700000000, body)
+
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, signature)
+	assert.True(t, events.Verify("secret", 1700000000, body, signature))
+	assert.False(t, events.Verify("other", 1700000000, body, signature), "wrong secret")
+	assert.False(t, events.Verify("secret", 1700000001, body, signature), "wrong timestamp")
+	assert.False(t, events.Verify("secret", 1700000000, []byte(`{"id":"2"}`), signature), "tampered body")
+}
+
+func TestPublicOnly(t *testing.T) {
+	refused := []string{
+		"127.0.0.1:80",
+		"[::1]:80",
+		"169.254.169.254:80",
+		"[fe80::1]:80",
+		"10.0.0.1:443",
+		"172.16.5.4:443",
+		"192.168.1.1:443",
+		"100.64.0.1:443",
+		"0.0.0.0:80",
+		"[::ffff:127.0.0.1]:80",
+		"[fd00::1]:443",
+		"224.0.0.1:80",
+	}
+	for _, address := range refused {
+		assert.ErrorIs(t, events.PublicOnly("tcp", address, nil), events.ErrPrivateDestination, address)
+	}
+
+	for _, address := range []string{"93.184.216.34:443", "[2606:2800:220:1::1]:443"} {
+		assert.NoError(t, events.PublicOnly("tcp", address, nil), address)
+	}
+}
+
+func TestNewPublicClient_RefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	publisher := &events.WebhookPublisher{URL: server.URL, Client: events.NewPublicClient(time.Second)}
+	assert.ErrorIs(t, publisher.Publish(context.Background(), newEvent(t)), events.ErrPrivateDestination)
+}
+
+func TestMultiPublisher_StopsAtFirstFailure(t *testing.T) {
+	first, second := &events.MemoryPublisher{}, &events.MemoryPublisher{}
+	multi := events.MultiPublisher{first, second}
+
+	event := newEvent(t)
+	require.NoError(t, multi.Publish(context.Background(), event))
+	assert.Len(t, first.Events(), 1)
+	assert.Len(t, second.Events(), 1)
+
+	first.FailWith(errors.New("down"))
+	assert.EqualError(t, multi.Publish(context.Background(), event), "down")
+	assert.Len(t, second.Events(), 1, "later publishers are skipped")
+}
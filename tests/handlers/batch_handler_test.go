@@ -0,0 +1,158 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/handlers"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func createWallet(t *testing.T, r *gin.Engine) dto.WalletResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/wallets/", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var wallet dto.WalletResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &wallet))
+	return wallet
+}
+
+func balanceOf(t *testing.T, r *gin.Engine, id uuid.UUID) int64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/wallets/"+fmt.Sprint(id), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var wallet dto.WalletResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &wallet))
+	return wallet.Balance
+}
+
+func postBatch(t *testing.T, r *gin.Engine, path string, request dto.BatchOperationRequest) (*httptest.ResponseRecorder, dto.BatchOperationResponse) {
+	t.Helper()
+	body, _ := json.Marshal(request)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	var resp dto.BatchOperationResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+const batchPath = "/api/v1/wallets/operations:batch"
+
+func TestBatch_Atomic(t *testing.T) {
+	r := newRouter(t)
+	a, b := createWallet(t, r), createWallet(t, r)
+
+	w, resp := postBatch(t, r, batchPath, dto.BatchOperationRequest{
+		Mode: dto.BatchModeAtomic,
+		Operations: []dto.WalletOperationRequest{
+			{WalletID: a.WalletID, OperationType: string(enums.DEPOSIT), Amount: 100},
+			{WalletID: a.WalletID, OperationType: string(enums.TRANSFER), Amount: 40, ToWalletID: &b.WalletID},
+			{WalletID: b.WalletID, OperationType: string(enums.WITHDRAW), Amount: 10},
+		},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, resp.Succeeded)
+	assert.Equal(t, 0, resp.Failed)
+	assert.Len(t, resp.Results, 3)
+	assert.Equal(t, int64(100), resp.Results[0].Wallet.Balance)
+	assert.Equal(t, int64(60), resp.Results[1].Wallet.Balance)
+	assert.Equal(t, int64(30), resp.Results[2].Wallet.Balance)
+
+	assert.Equal(t, int64(60), balanceOf(t, r, a.WalletID))
+	assert.Equal(t, int64(30), balanceOf(t, r, b.WalletID))
+}
+
+func TestBatch_Atomic_RollsBack(t *testing.T) {
+	r := newRouter(t)
+	a, b := createWallet(t, r), createWallet(t, r)
+
+	w, resp := postBatch(t, r, batchPath, dto.BatchOperationRequest{
+		Mode: dto.BatchModeAtomic,
+		Operations: []dto.WalletOperationRequest{
+			{WalletID: a.WalletID, OperationType: string(enums.DEPOSIT), Amount: 100},
+			{WalletID: b.WalletID, OperationType: string(enums.WITHDRAW), Amount: 10},
+		},
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 0, resp.Succeeded)
+	assert.Equal(t, 2, resp.Failed)
+	assert.Equal(t, "rolled_back", resp.Results[0].Status)
+	assert.Equal(t, handlers.CodeBatchRolledBack, resp.Results[0].Code)
+	assert.Equal(t, "failed", resp.Results[1].Status)
+	assert.Equal(t, handlers.CodeInsufficientFunds, resp.Results[1].Code)
+
+	assert.Equal(t, int64(0), balanceOf(t, r, a.WalletID))
+}
+
+func TestBatch_BestEffort(t *testing.T) {
+	r := newRouter(t)
+	a := createWallet(t, r)
+
+	w, resp := postBatch(t, r, batchPath, dto.BatchOperationRequest{
+		Mode: dto.BatchModeBestEffort,
+		Operations: []dto.WalletOperationRequest{
+			{WalletID: a.WalletID, OperationType: string(enums.DEPOSIT), Amount: 100},
+			{WalletID: a.WalletID, OperationType: string(enums.WITHDRAW), Amount: 500},
+			{WalletID: uuid.New(), OperationType: string(enums.DEPOSIT), Amount: 5},
+			{WalletID: a.WalletID, OperationType: string(enums.WITHDRAW), Amount: 30},
+		},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, resp.Succeeded)
+	assert.Equal(t, 2, resp.Failed)
+	assert.Equal(t, "applied", resp.Results[0].Status)
+	assert.Equal(t, handlers.CodeInsufficientFunds, resp.Results[1].Code)
+	assert.Equal(t, handlers.CodeWalletNotFound, resp.Results[2].Code)
+	assert.Equal(t, int64(70), resp.Results[3].Wallet.Balance)
+
+	assert.Equal(t, int64(70), balanceOf(t, r, a.WalletID))
+}
+
+func TestBatch_TooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := handlers.New(services.New(&repository.WalletGORMRepository{DB: newDB(t)}))
+	h.MaxBatchSize = 2
+	r := gin.New()
+	h.Initialize(r)
+
+	a := createWallet(t, r)
+	op := dto.WalletOperationRequest{WalletID: a.WalletID, OperationType: string(enums.DEPOSIT), Amount: 1}
+
+	w, _ := postBatch(t, r, batchPath, dto.BatchOperationRequest{
+		Mode:       dto.BatchModeBestEffort,
+		Operations: []dto.WalletOperationRequest{op, op, op},
+	})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeBatchTooLarge)
+	assert.Equal(t, int64(0), balanceOf(t, r, a.WalletID))
+}
+
+func TestBatch_Route(t *testing.T) {
+	r := newRouter(t)
+
+	w, _ := postBatch(t, r, "/api/v1/wallets/operations:batchx", dto.BatchOperationRequest{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, _ = postBatch(t, r, batchPath, dto.BatchOperationRequest{Mode: "sometimes"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,84 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/handlers"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newHealthRouter(db *gorm.DB, ready bool, pending []string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	h := handlers.NewHealthHandler(db, func() bool { return ready }, func() ([]string, error) {
+		return pending, nil
+	})
+
+	r := gin.New()
+	h.Initialize(r)
+	return r
+}
+
+func getReadiness(t *testing.T, r *gin.Engine) (int, dto.ReadinessResponse) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	r.ServeHTTP(w, req)
+
+	var resp dto.ReadinessResponse
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	return w.Code, resp
+}
+
+func TestReadiness_OK(t *testing.T) {
+	r := newHealthRouter(newDB(t), true, nil)
+
+	code, resp := getReadiness(t, r)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Database.Status)
+	assert.False(t, resp.Draining)
+	assert.Empty(t, resp.PendingMigrations)
+}
+
+func TestReadiness_Draining(t *testing.T) {
+	r := newHealthRouter(newDB(t), false, nil)
+
+	code, resp := getReadiness(t, r)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.True(t, resp.Draining)
+}
+
+func TestReadiness_PendingMigrations(t *testing.T) {
+	r := newHealthRouter(newDB(t), true, []string{"holds"})
+
+	code, resp := getReadiness(t, r)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"holds"}, resp.PendingMigrations)
+}
+
+func TestReadiness_DatabaseDown(t *testing.T) {
+	dsn := "host=localhost port=1 user=postgres password=postgres dbname=test_db sslmode=disable connect_timeout=1"
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{DisableAutomaticPing: true})
+	assert.NoError(t, err)
+
+	r := newHealthRouter(db, true, nil)
+
+	code, resp := getReadiness(t, r)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", resp.Database.Status)
+	assert.NotEmpty(t, resp.Database.Error)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,232 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/handlers"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/services"
+	"itk-academy-test/internal/streaming"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const streamDSN = "host=localhost port=5435 user=postgres password=postgres dbname=test_db sslmode=disable"
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents parses the server-sent events of body onto the returned
+// channel, which is closed when the stream ends.
+func readEvents(body *bufio.Scanner) <-chan sseEvent {
+	out := make(chan sseEvent, 16)
+	go func() {
+		defer close(out)
+		var event sseEvent
+		for body.Scan() {
+			line := body.Text()
+			switch {
+			case line == "":
+				if event.name != "" {
+					out <- event
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, "event:"):
+				event.name = strings.TrimPrefix(line, "event:")
+			case strings.HasPrefix(line, "data:"):
+				event.data = strings.TrimPrefix(line, "data:")
+			}
+		}
+	}()
+	return out
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "stream ended")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event within 5s")
+		return sseEvent{}
+	}
+}
+
+func nextBalance(t *testing.T, events <-chan sseEvent) dto.WalletResponse {
+	t.Helper()
+	event := nextEvent(t, events)
+	require.Equal(t, "balance", event.name)
+
+	var wallet dto.WalletResponse
+	require.NoError(t, json.Unmarshal([]byte(event.data), &wallet))
+	return wallet
+}
+
+// newStreamServer serves the wallet API over a real HTTP server, with the
+// balance streams fed by a listener on the test database. The API writes
+// through sessions that announce their changes; the returned DB does not.
+func newStreamServer(t *testing.T) (*httptest.Server, *gin.Engine, *gorm.DB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := newDB(t)
+
+	notifying, err := gorm.Open(postgres.Open(streaming.NotifyingDSN(streamDSN)), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := notifying.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	h := handlers.New(services.New(&repository.WalletGORMRepository{DB: notifying}))
+	h.Hub = streaming.NewHub()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streaming.NewListener(streamDSN, h.Hub).Run(ctx)
+	}()
+
+	r := gin.New()
+	h.Initialize(r)
+	server := httptest.NewServer(r)
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		h.Hub.Close()
+		server.Close()
+	})
+
+	// The LISTEN of the listener is the last statement of its session.
+	require.Eventually(t, func() bool {
+		var listening int64
+		db.Raw("SELECT count(*) FROM pg_stat_activity WHERE query = ?", "LISTEN "+streaming.Channel).Scan(&listening)
+		return listening > 0
+	}, 5*time.Second, 20*time.Millisecond)
+
+	return server, r, db
+}
+
+func openStream(t *testing.T, server *httptest.Server, id string) <-chan sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/api/v1/wallets/"+id+"/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return readEvents(bufio.NewScanner(resp.Body))
+}
+
+func TestStream_PushesCommittedChanges(t *testing.T) {
+	server, r, _ := newStreamServer(t)
+	wallet := createWallet(t, r)
+
+	events := openStream(t, server, wallet.WalletID.String())
+	initial := nextBalance(t, events)
+	assert.Equal(t, wallet.WalletID, initial.WalletID)
+	assert.Zero(t, initial.Balance)
+
+	w := doJSON(t, r, "POST", "/api/v1/wallet/", dto.WalletOperationRequest{
+		WalletID: wallet.WalletID, OperationType: string(enums.DEPOSIT), Amount: 100,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	deposited := nextBalance(t, events)
+	assert.Equal(t, int64(100), deposited.Balance)
+	assert.Equal(t, int64(100), deposited.Available)
+	assert.Equal(t, string(enums.RUB), deposited.Currency)
+
+	w = doJSON(t, r, "POST", "/api/v1/wallets/"+wallet.WalletID.String()+"/holds", dto.CreateHoldRequest{Amount: 30})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	held := nextBalance(t, events)
+	assert.Equal(t, int64(100), held.Balance)
+	assert.Equal(t, int64(70), held.Available)
+}
+
+func TestStream_IgnoresRolledBackChanges(t *testing.T) {
+	server, r, db := newStreamServer(t)
+	wallet := createWallet(t, r)
+
+	events := openStream(t, server, wallet.WalletID.String())
+	nextBalance(t, events)
+
+	tx := db.Begin()
+	require.NoError(t, tx.Exec("SET LOCAL "+streaming.NotifySetting+" = on").Error)
+	require.NoError(t, tx.Exec("UPDATE wallets SET balance = 500, version = version + 1 WHERE id = ?", wallet.WalletID).Error)
+	require.NoError(t, tx.Rollback().Error)
+
+	w := doJSON(t, r, "POST", "/api/v1/wallet/", dto.WalletOperationRequest{
+		WalletID: wallet.WalletID, OperationType: string(enums.DEPOSIT), Amount: 7,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.Equal(t, int64(7), nextBalance(t, events).Balance, "the rolled back update is never streamed")
+}
+
+func TestStream_SessionsWithoutNotifySettingStaySilent(t *testing.T) {
+	server, r, db := newStreamServer(t)
+	wallet := createWallet(t, r)
+
+	events := openStream(t, server, wallet.WalletID.String())
+	nextBalance(t, events)
+
+	require.NoError(t, db.Exec("UPDATE wallets SET balance = 500, version = version + 1 WHERE id = ?", wallet.WalletID).Error)
+
+	w := doJSON(t, r, "POST", "/api/v1/wallet/", dto.WalletOperationRequest{
+		WalletID: wallet.WalletID, OperationType: string(enums.DEPOSIT), Amount: 7,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.Equal(t, int64(507), nextBalance(t, events).Balance, "the silent update is only seen with the next announced one")
+}
+
+func TestStream_EndsWhenWalletDeleted(t *testing.T) {
+	server, r, _ := newStreamServer(t)
+	wallet := createWallet(t, r)
+
+	events := openStream(t, server, wallet.WalletID.String())
+	nextBalance(t, events)
+
+	w := doJSON(t, r, "DELETE", "/api/v1/wallets/"+wallet.WalletID.String(), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, "deleted", nextEvent(t, events).name)
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok, "the stream ends after the deleted event")
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open")
+	}
+}
+
+func TestStream_UnknownWallet(t *testing.T) {
+	server, _, _ := newStreamServer(t)
+
+	resp, err := http.Get(server.URL + "/api/v1/wallets/" + uuid.NewString() + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
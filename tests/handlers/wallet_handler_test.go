@@ -2,6 +2,7 @@ package handlers_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -12,12 +13,13 @@ import (
 	enums "itk-academy-test/internal"
 	"itk-academy-test/internal/dto"
 	"itk-academy-test/internal/handlers"
-	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/migrations"
 	"itk-academy-test/internal/repository"
 	"itk-academy-test/internal/services"
 
 	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -48,11 +50,20 @@ func newDB(t *testing.T) *gorm.DB {
 		t.Fatalf("failed to connect DB: %v", err)
 	}
 
-	err = db.Migrator().DropTable(&models.Wallet{})
+	err = db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error
 	if err != nil {
-		t.Fatalf("drop table: %v", err)
+		t.Fatalf("reset schema: %v", err)
 	}
-	if err := db.AutoMigrate(&models.Wallet{}); err != nil {
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("get sql.DB: %v", err)
+	}
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
 		t.Fatalf("migrate: %v", err)
 	}
 	return db
@@ -85,7 +96,7 @@ func TestCreateWallet(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.NoError(t, err)
 	assert.NotZero(t, resp.WalletID)
-	assert.Equal(t, 0, resp.Balance)
+	assert.Equal(t, int64(0), resp.Balance)
 	assert.Equal(t, "Wallet created", resp.Message)
 }
 
@@ -101,7 +112,7 @@ func TestAmount(t *testing.T) {
 	err := json.Unmarshal(w1.Body.Bytes(), &created)
 	assert.NoError(t, err)
 	assert.NotZero(t, created.WalletID)
-	assert.Equal(t, 0, created.Balance)
+	assert.Equal(t, int64(0), created.Balance)
 
 	opBody, _ := json.Marshal(dto.WalletOperationRequest{
 		WalletID:      created.WalletID,
@@ -125,7 +136,7 @@ func TestAmount(t *testing.T) {
 	assert.NoError(t, err)
 
 	assert.Equal(t, created.WalletID, resp.WalletID)
-	assert.Equal(t, 150, resp.Balance)
+	assert.Equal(t, int64(150), resp.Balance)
 	assert.Equal(t, "", resp.Message)
 }
 
@@ -155,7 +166,7 @@ func TestOperation_Deposit(t *testing.T) {
 	var resp dto.WalletResponse
 	_ = json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.Equal(t, created.WalletID, resp.WalletID)
-	assert.Equal(t, 200, resp.Balance)
+	assert.Equal(t, int64(200), resp.Balance)
 	assert.Equal(t, "Operation completed successfully", resp.Message)
 }
 
@@ -180,8 +191,9 @@ func TestOperation_Withdraw_Insufficient(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
 	assert.Contains(t, w.Body.String(), "Insufficient funds")
+	assert.Contains(t, w.Body.String(), handlers.CodeInsufficientFunds)
 }
 
 func TestDeleteWallet(t *testing.T) {
@@ -202,3 +214,356 @@ func TestDeleteWallet(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Wallet deleted")
 }
+
+func TestTransactions(t *testing.T) {
+	r := newRouter(t)
+
+	w1 := httptest.NewRecorder()
+	req1, _ := http.NewRequest("POST", "/api/v1/wallets/", nil)
+	r.ServeHTTP(w1, req1)
+	assert.Equal(t, http.StatusOK, w1.Code)
+
+	var created dto.WalletResponse
+	_ = json.Unmarshal(w1.Body.Bytes(), &created)
+
+	for _, op := range []enums.OperationType{enums.DEPOSIT, enums.DEPOSIT, enums.WITHDRAW} {
+		body, _ := json.Marshal(dto.WalletOperationRequest{
+			WalletID:      created.WalletID,
+			OperationType: string(op),
+			Amount:        10,
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/wallets/"+fmt.Sprint(created.WalletID)+"/transactions?limit=2", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var page dto.TransactionListResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, string(enums.WITHDRAW), page.Items[0].OperationType)
+	assert.Equal(t, int64(10), page.Items[0].BalanceAfter)
+	assert.NotEmpty(t, page.NextCursor)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/wallets/"+fmt.Sprint(created.WalletID)+"/transactions?limit=2&cursor="+page.NextCursor, nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	page = dto.TransactionListResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Len(t, page.Items, 1)
+	assert.Equal(t, int64(10), page.Items[0].BalanceAfter)
+	assert.Empty(t, page.NextCursor)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/wallets/"+fmt.Sprint(created.WalletID)+"/transactions?operationType=WITHDRAW", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	page = dto.TransactionListResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Len(t, page.Items, 1)
+}
+
+func TestOperation_IdempotencyKey(t *testing.T) {
+	r := newRouter(t)
+
+	w1 := httptest.NewRecorder()
+	req1, _ := http.NewRequest("POST", "/api/v1/wallets/", nil)
+	r.ServeHTTP(w1, req1)
+	assert.Equal(t, http.StatusOK, w1.Code)
+
+	var created dto.WalletResponse
+	_ = json.Unmarshal(w1.Body.Bytes(), &created)
+
+	body, _ := json.Marshal(dto.WalletOperationRequest{
+		WalletID:      created.WalletID,
+		OperationType: string(enums.DEPOSIT),
+		Amount:        100,
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(handlers.IdempotencyKeyHeader, "deposit-1")
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var resp dto.WalletResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, int64(100), resp.Balance)
+	}
+
+	otherBody, _ := json.Marshal(dto.WalletOperationRequest{
+		WalletID:      created.WalletID,
+		OperationType: string(enums.DEPOSIT),
+		Amount:        200,
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(otherBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(handlers.IdempotencyKeyHeader, "deposit-1")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/wallets/"+fmt.Sprint(created.WalletID), nil)
+	r.ServeHTTP(w, req)
+
+	var resp dto.WalletResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, int64(100), resp.Balance)
+}
+
+func TestOperation_Transfer(t *testing.T) {
+	r := newRouter(t)
+
+	wallets := make([]dto.WalletResponse, 2)
+	for i := range wallets {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/wallets/", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		_ = json.Unmarshal(w.Body.Bytes(), &wallets[i])
+	}
+
+	body, _ := json.Marshal(dto.WalletOperationRequest{
+		WalletID:      wallets[0].WalletID,
+		OperationType: string(enums.DEPOSIT),
+		Amount:        100,
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body, _ = json.Marshal(dto.WalletOperationRequest{
+		WalletID:      wallets[0].WalletID,
+		OperationType: string(enums.TRANSFER),
+		Amount:        30,
+		ToWalletID:    &wallets[1].WalletID,
+	})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp dto.WalletResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, int64(70), resp.Balance)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/wallets/"+fmt.Sprint(wallets[1].WalletID), nil)
+	r.ServeHTTP(w, req)
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, int64(30), resp.Balance)
+}
+
+func TestAmount_NotFound(t *testing.T) {
+	r := newRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/wallets/"+uuid.NewString(), nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var resp map[string]string
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, handlers.CodeWalletNotFound, resp["code"])
+}
+
+func TestDeleteWallet_NotFound(t *testing.T) {
+	r := newRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/wallets/"+uuid.NewString(), nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeWalletNotFound)
+}
+
+func TestOperation_InvalidRequest(t *testing.T) {
+	r := newRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader([]byte(`{"operationType":"HELLO"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeInvalidRequest)
+}
+
+func TestCreateWallet_Currency(t *testing.T) {
+	r := newRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/wallets/", bytes.NewReader([]byte(`{"currency":"USD"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var created dto.WalletResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, "USD", created.Currency)
+
+	body, _ := json.Marshal(dto.WalletOperationRequest{
+		WalletID:      created.WalletID,
+		OperationType: string(enums.DEPOSIT),
+		Amount:        100,
+		Currency:      "EUR",
+	})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeCurrencyMismatch)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/wallets/", bytes.NewReader([]byte(`{"currency":"JPY"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeUnsupportedCurrency)
+}
+
+func TestListWallets(t *testing.T) {
+	r := newRouter(t)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/wallets/", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/wallets/?limit=2&sortBy=createdAt&order=asc", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var page dto.WalletListResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Len(t, page.Items, 2)
+	assert.Equal(t, int64(3), page.Total)
+	assert.NotEmpty(t, page.NextCursor)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/wallets/?limit=2&sortBy=createdAt&order=asc&cursor="+page.NextCursor, nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	page = dto.WalletListResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &page)
+	assert.Len(t, page.Items, 1)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestHolds_CaptureAndRelease(t *testing.T) {
+	r := newRouter(t)
+
+	w1 := httptest.NewRecorder()
+	req1, _ := http.NewRequest("POST", "/api/v1/wallets/", nil)
+	r.ServeHTTP(w1, req1)
+	assert.Equal(t, http.StatusOK, w1.Code)
+
+	var created dto.WalletResponse
+	_ = json.Unmarshal(w1.Body.Bytes(), &created)
+
+	body, _ := json.Marshal(dto.WalletOperationRequest{
+		WalletID:      created.WalletID,
+		OperationType: string(enums.DEPOSIT),
+		Amount:        100,
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	createHold := func(amount int64) (int, dto.HoldResponse) {
+		body, _ := json.Marshal(dto.CreateHoldRequest{Amount: amount, TTLSeconds: 60})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/wallets/"+fmt.Sprint(created.WalletID)+"/holds", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+
+		var resp dto.HoldResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, captured := createHold(60)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, int64(100), captured.Wallet.Balance)
+	assert.Equal(t, int64(40), captured.Wallet.Available)
+
+	code, _ = createHold(50)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+
+	body, _ = json.Marshal(dto.WalletOperationRequest{
+		WalletID:      created.WalletID,
+		OperationType: string(enums.WITHDRAW),
+		Amount:        50,
+	})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/wallet/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/holds/"+fmt.Sprint(captured.ID)+"/capture", bytes.NewReader([]byte(`{"amount":45}`)))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp dto.HoldResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, string(enums.HoldCaptured), resp.Status)
+	assert.Equal(t, int64(55), resp.Wallet.Balance)
+	assert.Equal(t, int64(55), resp.Wallet.Available)
+
+	code, released := createHold(30)
+	assert.Equal(t, http.StatusCreated, code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/holds/"+fmt.Sprint(released.ID)+"/release", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	resp = dto.HoldResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, string(enums.HoldReleased), resp.Status)
+	assert.Equal(t, int64(55), resp.Wallet.Available)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/holds/"+fmt.Sprint(released.ID)+"/release", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeHoldNotActive)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/wallets/"+fmt.Sprint(created.WalletID)+"/transactions?operationType=CAPTURE", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var history dto.TransactionListResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &history)
+	if assert.Len(t, history.Items, 1) {
+		assert.Equal(t, int64(45), history.Items[0].Amount)
+	}
+}
@@ -0,0 +1,240 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/handlers"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookFixture struct {
+	router   *gin.Engine
+	wallets  *services.WalletService
+	webhooks *services.WebhookService
+}
+
+func newWebhookFixture(t *testing.T) webhookFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := newDB(t)
+
+	wallets := services.New(&repository.WalletGORMRepository{DB: db})
+	// The receivers listen on loopback, which the default client refuses.
+	webhooks := services.NewWebhookService(&repository.WebhookGORMRepository{DB: db}, &http.Client{},
+		services.WebhookRetryPolicy{MaxAttempts: 1})
+
+	r := gin.New()
+	handlers.New(wallets).Initialize(r)
+	handlers.NewWebhookHandler(webhooks).Initialize(r)
+	return webhookFixture{router: r, wallets: wallets, webhooks: webhooks}
+}
+
+// relay publishes the pending outbox events to the webhook subscriptions
+// and makes one attempt at every delivery.
+func (f webhookFixture) relay(t *testing.T) {
+	t.Helper()
+	_, err := f.wallets.PublishEvents(context.Background(), f.webhooks, 100)
+	require.NoError(t, err)
+	_, err = f.webhooks.DispatchDeliveries(context.Background(), 100)
+	require.NoError(t, err)
+}
+
+func doJSON(t *testing.T, r *gin.Engine, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func createWebhook(t *testing.T, r *gin.Engine, url string, eventTypes ...string) dto.WebhookResponse {
+	t.Helper()
+	w := doJSON(t, r, "POST", "/api/v1/webhooks", dto.CreateWebhookRequest{URL: url, EventTypes: eventTypes})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var webhook dto.WebhookResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &webhook))
+	return webhook
+}
+
+func webhookDeliveries(t *testing.T, r *gin.Engine, id uuid.UUID, query string) []dto.WebhookDeliveryResponse {
+	t.Helper()
+	w := doJSON(t, r, "GET", "/api/v1/webhooks/"+id.String()+"/deliveries"+query, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp dto.WebhookDeliveryListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Items
+}
+
+func TestWebhooks_CRUD(t *testing.T) {
+	f := newWebhookFixture(t)
+
+	created := createWebhook(t, f.router, "https://example.com/hook", models.EventWalletCreated)
+	assert.NotEmpty(t, created.Secret)
+	assert.True(t, created.Active)
+
+	w := doJSON(t, f.router, "GET", "/api/v1/webhooks/"+created.ID.String(), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret, "the secret is only returned on creation")
+
+	inactive := false
+	w = doJSON(t, f.router, "PUT", "/api/v1/webhooks/"+created.ID.String(), dto.UpdateWebhookRequest{
+		URL:        "https://example.com/other",
+		EventTypes: []string{models.EventWalletCredited, models.EventWalletDebited},
+		Active:     &inactive,
+	})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = doJSON(t, f.router, "GET", "/api/v1/webhooks", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list dto.WebhookListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "https://example.com/other", list.Items[0].URL)
+	assert.Equal(t, []string{models.EventWalletCredited, models.EventWalletDebited}, list.Items[0].EventTypes)
+	assert.False(t, list.Items[0].Active)
+
+	w = doJSON(t, f.router, "DELETE", "/api/v1/webhooks/"+created.ID.String(), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doJSON(t, f.router, "GET", "/api/v1/webhooks/"+created.ID.String(), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeSubscriptionNotFound)
+}
+
+func TestWebhooks_InvalidRequests(t *testing.T) {
+	f := newWebhookFixture(t)
+
+	w := doJSON(t, f.router, "POST", "/api/v1/webhooks", dto.CreateWebhookRequest{URL: "not a url", EventTypes: []string{models.EventWalletCreated}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeInvalidRequest)
+
+	w = doJSON(t, f.router, "POST", "/api/v1/webhooks", dto.CreateWebhookRequest{URL: "https://example.com", EventTypes: []string{"wallet.exploded"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeInvalidEventTypes)
+
+	w = doJSON(t, f.router, "PUT", "/api/v1/webhooks/"+uuid.NewString(), dto.UpdateWebhookRequest{URL: "https://example.com", EventTypes: []string{models.EventWalletCreated}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doJSON(t, f.router, "GET", "/api/v1/webhooks/"+uuid.NewString()+"/deliveries", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWebhooks_DeliverSignedEvents(t *testing.T) {
+	f := newWebhookFixture(t)
+
+	var mu sync.Mutex
+	var headers []http.Header
+	var bodies [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		headers, bodies = append(headers, r.Header.Clone()), append(bodies, body)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	webhook := createWebhook(t, f.router, receiver.URL, models.EventWalletCreated, models.EventWalletCredited)
+	wallet := createWallet(t, f.router)
+	w := doJSON(t, f.router, "POST", "/api/v1/wallet/", dto.WalletOperationRequest{
+		WalletID: wallet.WalletID, OperationType: string(enums.DEPOSIT), Amount: 100,
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	f.relay(t)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, headers, 2)
+	types := make([]string, 0, 2)
+	for i, header := range headers {
+		timestamp, err := strconv.ParseInt(header.Get(events.WebhookTimestampHeader), 10, 64)
+		require.NoError(t, err)
+		assert.True(t, events.Verify(webhook.Secret, timestamp, bodies[i], header.Get(events.WebhookSignatureHeader)))
+
+		var event models.OutboxEvent
+		require.NoError(t, json.Unmarshal(bodies[i], &event))
+		assert.Equal(t, wallet.WalletID, event.WalletID)
+		types = append(types, event.Type)
+	}
+	assert.ElementsMatch(t, []string{models.EventWalletCreated, models.EventWalletCredited}, types)
+
+	deliveries := webhookDeliveries(t, f.router, webhook.ID, "?status=DELIVERED")
+	assert.Len(t, deliveries, 2)
+	for _, d := range deliveries {
+		assert.Equal(t, 1, d.Attempts)
+		assert.Equal(t, http.StatusOK, *d.LastStatusCode)
+	}
+}
+
+func TestWebhooks_DeadLetterAndRetry(t *testing.T) {
+	f := newWebhookFixture(t)
+
+	var mu sync.Mutex
+	status := http.StatusInternalServerError
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	webhook := createWebhook(t, f.router, receiver.URL, models.EventWalletCreated)
+	createWallet(t, f.router)
+	f.relay(t)
+
+	dead := webhookDeliveries(t, f.router, webhook.ID, "?status=DEAD")
+	require.Len(t, dead, 1)
+	assert.Equal(t, http.StatusInternalServerError, *dead[0].LastStatusCode)
+	assert.NotNil(t, dead[0].LastError)
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+
+	retryPath := "/api/v1/webhooks/" + webhook.ID.String() + "/deliveries/" + dead[0].ID.String() + "/retry"
+	w := doJSON(t, f.router, "POST", retryPath, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	_, err := f.webhooks.DispatchDeliveries(context.Background(), 100)
+	require.NoError(t, err)
+
+	deliveries := webhookDeliveries(t, f.router, webhook.ID, "")
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, string(enums.DeliveryDelivered), deliveries[0].Status)
+
+	w = doJSON(t, f.router, "POST", retryPath, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeDeliveryNotDead)
+
+	w = doJSON(t, f.router, "POST", "/api/v1/webhooks/"+webhook.ID.String()+"/deliveries/"+uuid.NewString()+"/retry", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), handlers.CodeDeliveryNotFound)
+}
@@ -0,0 +1,109 @@
+package logging_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"itk-academy-test/internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRouter(buf *bytes.Buffer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(logging.Middleware(logging.New(buf, slog.LevelDebug)))
+	r.Use(logging.Recovery())
+	r.GET("/wallets/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("Handler ran", "wallet_id", c.Param("id"))
+		c.Status(http.StatusOK)
+	})
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	return r
+}
+
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]any
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		out = append(out, line)
+	}
+	return out
+}
+
+func TestMiddleware_GeneratesRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRouter(&buf)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/wallets/42", nil))
+
+	id := w.Header().Get(logging.RequestIDHeader)
+	_, err := uuid.Parse(id)
+	assert.NoError(t, err)
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "Handler ran", logged[0]["msg"])
+	assert.Equal(t, "42", logged[0]["wallet_id"])
+	for _, line := range logged {
+		assert.Equal(t, id, line["request_id"])
+	}
+	assert.Equal(t, "/wallets/:id", logged[1]["route"])
+	assert.Equal(t, float64(http.StatusOK), logged[1]["status"])
+}
+
+func TestMiddleware_KeepsIncomingRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRouter(&buf)
+
+	req := httptest.NewRequest(http.MethodGet, "/wallets/42", nil)
+	req.Header.Set(logging.RequestIDHeader, "req-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, "req-123", w.Header().Get(logging.RequestIDHeader))
+	for _, line := range lines(t, &buf) {
+		assert.Equal(t, "req-123", line["request_id"])
+	}
+}
+
+func TestMiddleware_ReplacesUnusableRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRouter(&buf)
+
+	req := httptest.NewRequest(http.MethodGet, "/wallets/42", nil)
+	req.Header.Set(logging.RequestIDHeader, strings.Repeat("x", 500))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	_, err := uuid.Parse(w.Header().Get(logging.RequestIDHeader))
+	assert.NoError(t, err)
+}
+
+func TestRecovery_LogsPanic(t *testing.T) {
+	var buf bytes.Buffer
+	r := newRouter(&buf)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	logged := lines(t, &buf)
+	require.Len(t, logged, 2)
+	assert.Equal(t, "Panic recovered", logged[0]["msg"])
+	assert.Equal(t, "ERROR", logged[1]["level"])
+	assert.Equal(t, w.Header().Get(logging.RequestIDHeader), logged[0]["request_id"])
+}
@@ -0,0 +1,42 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"itk-academy-test/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_LabelsByRoutePattern(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(metrics.Middleware())
+	r.GET("/api/v1/wallets/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/metrics", metrics.Handler())
+
+	counter := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "/api/v1/wallets/:id", "200")
+	before := testutil.ToFloat64(counter)
+
+	for _, id := range []string{"a", "b", "c"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/wallets/"+id, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, before+3, testutil.ToFloat64(counter))
+
+	notFound := metrics.HTTPRequests.WithLabelValues(http.MethodGet, "unmatched", "404")
+	before = testutil.ToFloat64(notFound)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nope", nil))
+	assert.Equal(t, before+1, testutil.ToFloat64(notFound))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `wallet_http_requests_total{method="GET",route="/api/v1/wallets/:id",status="200"}`))
+}
@@ -0,0 +1,140 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"itk-academy-test/internal/migrations"
+	"itk-academy-test/internal/models"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var epg *embeddedpostgres.EmbeddedPostgres
+
+func TestMain(m *testing.M) {
+	epg = embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Port(5436).
+		Username("postgres").
+		Password("postgres").
+		Database("test_db"),
+	)
+	if err := epg.Start(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = epg.Stop()
+	os.Exit(code)
+}
+
+func newDB(t *testing.T) (*gorm.DB, *sql.DB) {
+	t.Helper()
+	dsn := "host=localhost port=5436 user=postgres password=postgres dbname=test_db sslmode=disable"
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error)
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	return db, sqlDB
+}
+
+func TestAll_LoadsEmbeddedMigrations(t *testing.T) {
+	all, err := migrations.All()
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+
+	for i, m := range all {
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+		if i > 0 {
+			assert.Greater(t, m.Version, all[i-1].Version)
+		}
+	}
+}
+
+func TestMigrator_UpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	_, sqlDB := newDB(t)
+
+	migrator, err := migrations.New(sqlDB)
+	require.NoError(t, err)
+
+	pending, err := migrator.Pending(ctx)
+	require.NoError(t, err)
+	all, _ := migrations.All()
+	assert.Len(t, pending, len(all))
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(all))
+
+	pending, err = migrator.Pending(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	reverted, err := migrator.Down(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, reverted)
+	assert.Equal(t, migrator.Latest(), reverted.Version)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.False(t, statuses[len(statuses)-1].Applied)
+	assert.True(t, statuses[0].Applied)
+	assert.NotNil(t, statuses[0].AppliedAt)
+
+	ran, err := migrator.To(ctx, 0)
+	require.NoError(t, err)
+	assert.Len(t, ran, len(all)-1)
+
+	pending, err = migrator.Pending(ctx)
+	require.NoError(t, err)
+	assert.Len(t, pending, len(all))
+
+	ran, err = migrator.To(ctx, all[0].Version)
+	require.NoError(t, err)
+	assert.Len(t, ran, 1)
+
+	_, err = migrator.To(ctx, 9999)
+	assert.ErrorIs(t, err, migrations.ErrUnknownVersion)
+}
+
+// The models must keep matching the schema built by the migrations.
+func TestMigrations_MatchModels(t *testing.T) {
+	db, sqlDB := newDB(t)
+
+	migrator, err := migrations.New(sqlDB)
+	require.NoError(t, err)
+	_, err = migrator.Up(context.Background())
+	require.NoError(t, err)
+
+	for _, model := range []any{&models.Wallet{}, &models.Transaction{}, &models.Hold{}, &models.LedgerAccount{}, &models.JournalEntry{}, &models.Posting{}, &models.OutboxEvent{},
+		&models.WebhookSubscription{}, &models.WebhookDelivery{}} {
+		stmt := &gorm.Statement{DB: db}
+		require.NoError(t, stmt.Parse(model))
+
+		require.True(t, db.Migrator().HasTable(model), stmt.Schema.Table)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || field.IgnoreMigration {
+				continue
+			}
+			assert.True(t, db.Migrator().HasColumn(model, field.DBName), "%s.%s", stmt.Schema.Table, field.DBName)
+		}
+		for _, index := range stmt.Schema.ParseIndexes() {
+			assert.True(t, db.Migrator().HasIndex(model, index.Name), "%s index %s", stmt.Schema.Table, index.Name)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// accountBalance sums the postings of a ledger account.
+func accountBalance(t *testing.T, db *gorm.DB, code string) int64 {
+	t.Helper()
+	var sum int64
+	assert.NoError(t, db.Model(&models.Posting{}).Select("COALESCE(SUM(amount), 0)").Where("account_code = ?", code).Scan(&sum).Error)
+	return sum
+}
+
+// mismatchesOf keeps the mismatches of ids, so that wallets of other tests
+// sharing the database do not interfere.
+func mismatchesOf(report *repository.ReconciliationReport, ids ...uuid.UUID) []repository.WalletMismatch {
+	var found []repository.WalletMismatch
+	for _, m := range report.Mismatches {
+		for _, id := range ids {
+			if m.WalletID == id {
+				found = append(found, m)
+			}
+		}
+	}
+	return found
+}
+
+func TestLedger_OperationsPostBalancedEntries(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+	ctx := context.Background()
+
+	a, err := repo.Create(ctx, enums.RUB)
+	assert.NoError(t, err)
+	b, err := repo.Create(ctx, enums.RUB)
+	assert.NoError(t, err)
+
+	var accounts int64
+	assert.NoError(t, db.Model(&models.LedgerAccount{}).Where("wallet_id IN ?", []any{a.ID, b.ID}).Count(&accounts).Error)
+	assert.Equal(t, int64(4), accounts)
+
+	externalBefore := accountBalance(t, db, models.ExternalAccountCode(enums.RUB))
+
+	deposit := &models.Transaction{OperationType: enums.DEPOSIT, Amount: 100}
+	_, err = repo.AdjustBalance(ctx, a.ID, 100, "", deposit)
+	assert.NoError(t, err)
+
+	_, _, err = repo.TransferAtomic(ctx, a.ID, b.ID, &models.Transaction{OperationType: enums.TRANSFER, Amount: 30}, func(from, to *models.Wallet) error {
+		from.Balance -= 30
+		to.Balance += 30
+		return nil
+	})
+	assert.NoError(t, err)
+
+	hold := &models.Hold{WalletID: a.ID, Amount: 50, Status: enums.HoldActive, ExpiresAt: time.Now().Add(time.Minute)}
+	_, err = repo.CreateHold(ctx, hold, &models.Transaction{OperationType: enums.HOLD, Amount: 50}, func(w *models.Wallet) error {
+		w.Held += 50
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// A partial capture takes 20 and releases the other 30 of the hold.
+	_, _, err = repo.OperateHold(ctx, hold.ID, &models.Transaction{OperationType: enums.CAPTURE, Amount: 20}, func(w *models.Wallet, h *models.Hold) error {
+		w.Held -= h.Amount
+		w.Balance -= 20
+		h.CapturedAmount = 20
+		h.Status = enums.HoldCaptured
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(50), accountBalance(t, db, models.WalletAccountCode(a.ID)))
+	assert.Equal(t, int64(0), accountBalance(t, db, models.HoldAccountCode(a.ID)))
+	assert.Equal(t, int64(30), accountBalance(t, db, models.WalletAccountCode(b.ID)))
+	assert.Equal(t, int64(-80), accountBalance(t, db, models.ExternalAccountCode(enums.RUB))-externalBefore)
+
+	var entry models.JournalEntry
+	assert.NoError(t, db.Preload("Postings").First(&entry, "transaction_id = ?", deposit.ID).Error)
+	assert.Equal(t, models.EntryOperation, entry.Kind)
+	assert.Len(t, entry.Postings, 2)
+
+	report, err := repo.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, mismatchesOf(report, a.ID, b.ID))
+	assert.Empty(t, report.UnbalancedEntries)
+}
+
+// Deposits and withdrawals made through the service take the single
+// statement path of AdjustBalance, or the batch path when coalesced; both
+// must leave the wallets matching their ledger without any adjustment.
+func TestLedger_ServiceOperationsReconcile(t *testing.T) {
+	repo := &repository.WalletGORMRepository{DB: setupTestDB(t)}
+	ctx := context.Background()
+
+	plain := services.New(repo)
+	coalesced := services.New(repo)
+	coalesced.EnableCoalescing(10*time.Millisecond, 10)
+
+	var ids []uuid.UUID
+	for _, svc := range []*services.WalletService{plain, coalesced} {
+		wallet, err := svc.Create(ctx, enums.RUB)
+		assert.NoError(t, err)
+		ids = append(ids, wallet.ID)
+
+		_, err = svc.Operation(ctx, wallet.ID, enums.DEPOSIT, 100, "")
+		assert.NoError(t, err)
+		_, err = svc.Operation(ctx, wallet.ID, enums.WITHDRAW, 30, enums.RUB)
+		assert.NoError(t, err)
+		_, _, err = svc.OperationWithKey(ctx, uuid.NewString(), wallet.ID, enums.DEPOSIT, 5, "")
+		assert.NoError(t, err)
+
+		assert.Equal(t, int64(75), accountBalance(t, repo.DB, models.WalletAccountCode(wallet.ID)))
+	}
+
+	report, err := plain.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, mismatchesOf(report, ids...))
+	assert.Empty(t, report.UnbalancedEntries)
+
+	for _, id := range ids {
+		entry, err := plain.AdjustLedger(ctx, id)
+		assert.NoError(t, err)
+		assert.Nil(t, entry, "nothing is left for --fix to book")
+	}
+}
+
+func TestLedger_RejectsUnbalancedEntry(t *testing.T) {
+	db := setupTestDB(t)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&models.JournalEntry{
+			ID:       uuid.New(),
+			Kind:     models.EntryOperation,
+			Postings: []models.Posting{{AccountCode: models.ExternalAccountCode(enums.RUB), Amount: 10}},
+		}).Error
+	})
+	assert.Error(t, err)
+
+	var count int64
+	assert.NoError(t, db.Model(&models.Posting{}).Where("amount = 10 AND account_code = ?", models.ExternalAccountCode(enums.RUB)).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestLedger_ReconcileDetectsDrift(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+	ctx := context.Background()
+
+	wallet, err := repo.Create(ctx, enums.RUB)
+	assert.NoError(t, err)
+	_, err = repo.AdjustBalance(ctx, wallet.ID, 100, "", &models.Transaction{OperationType: enums.DEPOSIT, Amount: 100})
+	assert.NoError(t, err)
+
+	// Writing the projection directly bypasses the ledger.
+	assert.NoError(t, db.Exec("UPDATE wallets SET balance = 150 WHERE id = ?", wallet.ID).Error)
+	t.Cleanup(func() {
+		db.Exec("UPDATE wallets SET balance = 100 WHERE id = ?", wallet.ID)
+	})
+
+	report, err := repo.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.False(t, report.Clean())
+	assert.Equal(t, []repository.WalletMismatch{{
+		WalletID:      wallet.ID,
+		Currency:      enums.RUB,
+		Balance:       150,
+		LedgerBalance: 100,
+	}}, mismatchesOf(report, wallet.ID))
+}
+
+func TestLedger_AdjustLedger(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+	ctx := context.Background()
+
+	wallet, err := repo.Create(ctx, enums.RUB)
+	assert.NoError(t, err)
+	_, err = repo.AdjustBalance(ctx, wallet.ID, 100, "", &models.Transaction{OperationType: enums.DEPOSIT, Amount: 100})
+	assert.NoError(t, err)
+
+	entry, err := repo.AdjustLedger(ctx, wallet.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, entry, "a wallet that matches its ledger needs no adjustment")
+
+	assert.NoError(t, db.Exec("UPDATE wallets SET balance = 130, held = 20 WHERE id = ?", wallet.ID).Error)
+
+	entry, err = repo.AdjustLedger(ctx, wallet.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, models.EntryAdjustment, entry.Kind)
+		assert.Nil(t, entry.TransactionID)
+	}
+
+	assert.Equal(t, int64(110), accountBalance(t, db, models.WalletAccountCode(wallet.ID)))
+	assert.Equal(t, int64(20), accountBalance(t, db, models.HoldAccountCode(wallet.ID)))
+
+	got, err := repo.Get(ctx, wallet.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(130), got.Balance, "the wallet balance is left alone")
+
+	report, err := repo.Reconcile(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, mismatchesOf(report, wallet.ID))
+
+	_, err = repo.AdjustLedger(ctx, uuid.New())
+	assert.ErrorIs(t, err, repository.ErrWalletNotFound)
+}
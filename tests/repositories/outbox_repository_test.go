@@ -0,0 +1,200 @@
+package repositories_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventsOf keeps the events of walletID, in publishing order.
+func eventsOf(published []models.OutboxEvent, walletID uuid.UUID) []models.OutboxEvent {
+	var found []models.OutboxEvent
+	for _, e := range published {
+		if e.WalletID == walletID {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func typesOf(events []models.OutboxEvent) []string {
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestOutbox_OperationsQueueEvents(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+	ctx := context.Background()
+
+	a, err := repo.Create(ctx, enums.RUB)
+	require.NoError(t, err)
+	b, err := repo.Create(ctx, enums.RUB)
+	require.NoError(t, err)
+
+	deposit := &models.Transaction{OperationType: enums.DEPOSIT, Amount: 100}
+	_, err = repo.AdjustBalance(ctx, a.ID, 100, "", deposit)
+	require.NoError(t, err)
+
+	transfer := &models.Transaction{OperationType: enums.TRANSFER, Amount: 40}
+	_, _, err = repo.TransferAtomic(ctx, a.ID, b.ID, transfer, func(from, to *models.Wallet) error {
+		from.Balance -= 40
+		to.Balance += 40
+		return nil
+	})
+	require.NoError(t, err)
+
+	// A rolled back operation leaves no event behind.
+	_, err = repo.OperateAtomic(ctx, a.ID, &models.Transaction{OperationType: enums.DEPOSIT, Amount: 1}, func(w *models.Wallet) error {
+		w.Balance++
+		return errors.New("rejected")
+	})
+	require.Error(t, err)
+
+	require.NoError(t, repo.Delete(ctx, b.ID))
+
+	publisher := &events.MemoryPublisher{}
+	for {
+		published, err := repo.PublishEvents(ctx, 100, publisher.Publish)
+		require.NoError(t, err)
+		if published == 0 {
+			break
+		}
+	}
+
+	aEvents, bEvents := eventsOf(publisher.Events(), a.ID), eventsOf(publisher.Events(), b.ID)
+	assert.Equal(t, []string{models.EventWalletCreated, models.EventWalletCredited, models.EventWalletDebited}, typesOf(aEvents))
+	assert.Equal(t, []string{models.EventWalletCreated, models.EventWalletCredited, models.EventWalletDeleted}, typesOf(bEvents))
+
+	var credited models.WalletEvent
+	require.NoError(t, json.Unmarshal(aEvents[1].Payload, &credited))
+	assert.Equal(t, models.WalletEvent{
+		WalletID:      a.ID,
+		Currency:      enums.RUB,
+		Amount:        100,
+		Balance:       100,
+		OperationType: enums.DEPOSIT,
+		TransactionID: &deposit.ID,
+	}, credited)
+
+	var debited models.WalletEvent
+	require.NoError(t, json.Unmarshal(aEvents[2].Payload, &debited))
+	assert.Equal(t, int64(40), debited.Amount)
+	assert.Equal(t, int64(60), debited.Balance)
+	assert.Equal(t, &transfer.ID, debited.TransactionID)
+
+	var deleted models.WalletEvent
+	require.NoError(t, json.Unmarshal(bEvents[2].Payload, &deleted))
+	assert.Equal(t, int64(40), deleted.Balance)
+}
+
+func TestOutbox_PublishEvents_StopsAtFailure(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+	ctx := context.Background()
+
+	wallet, err := repo.Create(ctx, enums.RUB)
+	require.NoError(t, err)
+
+	publisher := &events.MemoryPublisher{}
+	publisher.FailWith(errors.New("broker down"))
+
+	published, err := repo.PublishEvents(ctx, 100, publisher.Publish)
+	assert.EqualError(t, err, "broker down")
+	assert.Zero(t, published)
+
+	var failed models.OutboxEvent
+	require.NoError(t, db.Where("published_at IS NULL").Order("seq").First(&failed).Error)
+	assert.Equal(t, 1, failed.Attempts)
+	assert.Equal(t, "broker down", *failed.LastError)
+	assert.True(t, failed.NextAttemptAt.After(time.Now()), "a failed event backs off")
+
+	publisher.FailWith(nil)
+	published, err = repo.PublishEvents(ctx, 100, publisher.Publish)
+	require.NoError(t, err)
+	assert.Zero(t, published, "the event is not due before its backoff has passed")
+
+	require.NoError(t, db.Model(&failed).Update("next_attempt_at", time.Now()).Error)
+	published, err = repo.PublishEvents(ctx, 100, publisher.Publish)
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Len(t, eventsOf(publisher.Events(), wallet.ID), 1)
+
+	var pending int64
+	require.NoError(t, db.Model(&models.OutboxEvent{}).Where("published_at IS NULL").Count(&pending).Error)
+	assert.Zero(t, pending)
+}
+
+func TestOutbox_PublishEvents_FailingEventDoesNotHoldUpOthers(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db, Outbox: repository.OutboxPolicy{BaseDelay: time.Hour}}
+	ctx := context.Background()
+
+	wallet, err := repo.Create(ctx, enums.RUB)
+	require.NoError(t, err)
+	_, err = repo.AdjustBalance(ctx, wallet.ID, 100, "", &models.Transaction{OperationType: enums.DEPOSIT, Amount: 100})
+	require.NoError(t, err)
+
+	publisher := &events.MemoryPublisher{}
+	rejectCreated := func(ctx context.Context, event models.OutboxEvent) error {
+		if event.Type == models.EventWalletCreated {
+			return errors.New("rejected")
+		}
+		return publisher.Publish(ctx, event)
+	}
+
+	published, err := repo.PublishEvents(ctx, 100, rejectCreated)
+	assert.EqualError(t, err, "rejected")
+	assert.Zero(t, published, "the run stops at the failed event")
+
+	published, err = repo.PublishEvents(ctx, 100, rejectCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, published, "the events after the failed one are handed back")
+	assert.Equal(t, []string{models.EventWalletCredited}, typesOf(eventsOf(publisher.Events(), wallet.ID)))
+
+	var created models.OutboxEvent
+	require.NoError(t, db.First(&created, "wallet_id = ? AND type = ?", wallet.ID, models.EventWalletCreated).Error)
+	assert.Nil(t, created.PublishedAt)
+	assert.Equal(t, 1, created.Attempts)
+	assert.Equal(t, "rejected", *created.LastError)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), created.NextAttemptAt, time.Minute)
+}
+
+func TestOutbox_PublishEvents_ClaimsAreLeased(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+	ctx := context.Background()
+
+	_, err := repo.Create(ctx, enums.RUB)
+	require.NoError(t, err)
+
+	second := &events.MemoryPublisher{}
+	published, err := repo.PublishEvents(ctx, 100, func(ctx context.Context, event models.OutboxEvent) error {
+		_, deadline := ctx.Deadline()
+		assert.True(t, deadline, "publishing ends with the lease")
+
+		// No transaction is held while publishing, so another relay
+		// proceeds, and the leased event is not claimed twice.
+		published, err := repo.PublishEvents(ctx, 100, second.Publish)
+		assert.NoError(t, err)
+		assert.Zero(t, published)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Empty(t, second.Events())
+}
@@ -1,73 +1,415 @@
 package repositories_test
 
 import (
+	"context"
+	"os"
 	"testing"
+	"time"
 
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/migrations"
 	"itk-academy-test/internal/models"
 	"itk-academy-test/internal/repository"
 
 	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormtracing "gorm.io/plugin/opentelemetry/tracing"
 )
 
-var postgresDB *embeddedpostgres.EmbeddedPostgres
+const testDSN = "host=localhost port=5434 user=postgres password=postgres dbname=test_db sslmode=disable"
 
-func setupTestDB(t *testing.T) *gorm.DB {
-	if postgresDB == nil {
-		postgresDB = embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
-			Port(5433).
-			Username("postgres").
-			Password("postgres").
-			Database("test_db"),
-		)
-		err := postgresDB.Start()
-		if err != nil {
-			t.Fatalf("failed to start embedded postgres: %v", err)
-		}
-		t.Cleanup(func() {
-			postgresDB.Stop()
-		})
+func TestMain(m *testing.M) {
+	epg := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Port(5434).
+		Username("postgres").
+		Password("postgres").
+		Database("test_db"),
+	)
+	if err := epg.Start(); err != nil {
+		panic(err)
 	}
+	code := m.Run()
+	_ = epg.Stop()
+	os.Exit(code)
+}
 
-	dsn := "host=localhost port=5433 user=postgres password=postgres dbname=test_db sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// setupTestDB connects to a freshly migrated, empty schema.
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open(testDSN), &gorm.Config{})
 	if err != nil {
 		t.Fatalf("failed to connect database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.Wallet{})
+	sqlDB, err := db.DB()
 	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public").Error; err != nil {
+		t.Fatalf("failed to reset schema: %v", err)
+	}
+
+	migrator, err := migrations.New(sqlDB)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 
 	return db
 }
+
 func TestWalletRepository_CRUD(t *testing.T) {
 	db := setupTestDB(t)
 	repo := &repository.WalletGORMRepository{DB: db}
 
-	wallet, err := repo.Create()
+	wallet, err := repo.Create(context.Background(), enums.RUB)
 	assert.NoError(t, err)
 	assert.NotZero(t, wallet.ID)
 
-	got, err := repo.Get(wallet.ID)
+	got, err := repo.Get(context.Background(), wallet.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, wallet.ID, got.ID)
 
 	got.Balance = 100
-	updated, err := repo.Update(got)
+	updated, err := repo.Update(context.Background(), got)
 	assert.NoError(t, err)
-	assert.Equal(t, int(100), updated.Balance)
+	assert.Equal(t, int64(0), updated.Balance, "Update must not write the balance")
 
-	all, err := repo.AllWallets()
+	all, total, err := repo.List(context.Background(), repository.WalletFilter{Limit: 10})
 	assert.NoError(t, err)
-	assert.Len(t, *all, 1)
+	assert.Len(t, all, 1)
+	assert.Equal(t, int64(1), total)
 
-	err = repo.Delete(wallet.ID)
+	err = repo.Delete(context.Background(), wallet.ID)
 	assert.NoError(t, err)
 
-	_, err = repo.Get(wallet.ID)
+	_, err = repo.Get(context.Background(), wallet.ID)
 	assert.Error(t, err)
 }
+
+func TestWalletRepository_OperateAtomic_RecordsTransaction(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	wallet, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+
+	record := &models.Transaction{OperationType: enums.DEPOSIT, Amount: 70}
+	_, err = repo.OperateAtomic(context.Background(), wallet.ID, record, func(w *models.Wallet) error {
+		w.Balance += 70
+		return nil
+	})
+	assert.NoError(t, err)
+
+	var got models.Transaction
+	err = db.First(&got, "wallet_id = ?", wallet.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, enums.DEPOSIT, got.OperationType)
+	assert.Equal(t, int64(70), got.Amount)
+	assert.Equal(t, int64(0), got.BalanceBefore)
+	assert.Equal(t, int64(70), got.BalanceAfter)
+}
+
+func TestWalletRepository_TransferAtomic(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	from, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+	to, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Model(&from).Update("balance", 100).Error)
+
+	record := &models.Transaction{OperationType: enums.TRANSFER, Amount: 25}
+	_, _, err = repo.TransferAtomic(context.Background(), from.ID, to.ID, record, func(fw, tw *models.Wallet) error {
+		fw.Balance -= 25
+		tw.Balance += 25
+		return nil
+	})
+	assert.NoError(t, err)
+
+	var debit, credit models.Transaction
+	assert.NoError(t, db.First(&debit, "wallet_id = ?", from.ID).Error)
+	assert.NoError(t, db.First(&credit, "wallet_id = ?", to.ID).Error)
+	assert.Equal(t, int64(75), debit.BalanceAfter)
+	assert.Equal(t, to.ID, *debit.CounterpartyID)
+	assert.Equal(t, int64(25), credit.BalanceAfter)
+	assert.Equal(t, from.ID, *credit.CounterpartyID)
+}
+
+func TestWalletRepository_List_Keyset(t *testing.T) {
+	db := setupTestDB(t)
+	assert.NoError(t, db.Exec("DELETE FROM wallets").Error)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	for _, balance := range []int64{10, 20, 20, 30} {
+		w, err := repo.Create(context.Background(), enums.RUB)
+		assert.NoError(t, err)
+		assert.NoError(t, db.Model(&w).Update("balance", balance).Error)
+	}
+
+	minBalance := int64(15)
+	filter := repository.WalletFilter{SortBy: repository.SortByBalance, MinBalance: &minBalance, Limit: 2}
+
+	page, total, err := repo.List(context.Background(), filter)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, page, 2)
+	assert.Equal(t, int64(20), page[0].Balance)
+
+	last := page[1]
+	filter.After = last.Balance
+	filter.AfterID = last.ID
+
+	page, _, err = repo.List(context.Background(), filter)
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, int64(30), page[0].Balance)
+}
+
+func TestWalletRepository_ExpiredHolds(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	wallet, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	stale := &models.Hold{WalletID: wallet.ID, Amount: 10, Status: enums.HoldActive, ExpiresAt: now.Add(-time.Minute)}
+	fresh := &models.Hold{WalletID: wallet.ID, Amount: 10, Status: enums.HoldActive, ExpiresAt: now.Add(time.Minute)}
+	for _, h := range []*models.Hold{stale, fresh} {
+		_, err := repo.CreateHold(context.Background(), h, nil, func(w *models.Wallet) error {
+			w.Balance += h.Amount
+			w.Held += h.Amount
+			return nil
+		})
+		assert.NoError(t, err)
+	}
+
+	holds, err := repo.ExpiredHolds(context.Background(), now, 10)
+	assert.NoError(t, err)
+
+	ids := make([]uuid.UUID, 0, len(holds))
+	for _, h := range holds {
+		ids = append(ids, h.ID)
+	}
+	assert.Contains(t, ids, stale.ID)
+	assert.NotContains(t, ids, fresh.ID)
+}
+
+func TestWalletRepository_Delete_ExpiresHolds(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	wallet, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+
+	hold := &models.Hold{WalletID: wallet.ID, Amount: 10, Status: enums.HoldActive, ExpiresAt: time.Now().Add(-time.Minute)}
+	_, err = repo.CreateHold(context.Background(), hold, nil, func(w *models.Wallet) error {
+		w.Balance += hold.Amount
+		w.Held += hold.Amount
+		return nil
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.Delete(context.Background(), wallet.ID))
+
+	got, err := repo.GetHold(context.Background(), hold.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, enums.HoldExpired, got.Status)
+
+	holds, err := repo.ExpiredHolds(context.Background(), time.Now(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, holds)
+}
+
+func TestWalletRepository_OperateAtomic_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	db := setupTestDB(t)
+	assert.NoError(t, db.Use(gormtracing.NewPlugin(gormtracing.WithTracerProvider(provider), gormtracing.WithoutMetrics())))
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	wallet, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+
+	_, err = repo.OperateAtomic(context.Background(), wallet.ID, nil, func(w *models.Wallet) error {
+		w.Balance += 10
+		return nil
+	})
+	assert.NoError(t, err)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+
+	operate, ok := spans["WalletGORMRepository.OperateAtomic"]
+	assert.True(t, ok)
+	lock, ok := spans["lockWallet"]
+	assert.True(t, ok)
+	assert.Equal(t, operate.SpanContext().SpanID(), lock.Parent().SpanID())
+
+	var lockQueries int
+	for _, s := range recorder.Ended() {
+		if s.Parent().SpanID() == lock.SpanContext().SpanID() {
+			lockQueries++
+		}
+	}
+	assert.Equal(t, 1, lockQueries)
+}
+
+func TestWalletRepository_BalanceConstraints(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	from, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+	to, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+
+	// The closures skip the service checks, so only the DB stands in the way.
+	_, err = repo.OperateAtomic(context.Background(), from.ID, nil, func(w *models.Wallet) error {
+		w.Balance -= 10
+		return nil
+	})
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	record := &models.Transaction{OperationType: enums.TRANSFER, Amount: 10}
+	_, _, err = repo.TransferAtomic(context.Background(), from.ID, to.ID, record, func(fw, tw *models.Wallet) error {
+		fw.Balance -= 10
+		tw.Balance += 10
+		return nil
+	})
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	_, err = repo.OperateAtomic(context.Background(), from.ID, nil, func(w *models.Wallet) error {
+		w.Held += 10
+		return nil
+	})
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	got, err := repo.Get(context.Background(), from.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), got.Balance)
+	assert.Equal(t, int64(0), got.Held)
+
+	var count int64
+	assert.NoError(t, db.Model(&models.Transaction{}).Where("wallet_id IN ?", []any{from.ID, to.ID}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestWalletRepository_AdjustBalance(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	wallet, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+
+	key := "adjust-" + wallet.ID.String()
+	deposit := &models.Transaction{OperationType: enums.DEPOSIT, Amount: 100, IdempotencyKey: &key}
+	got, err := repo.AdjustBalance(context.Background(), wallet.ID, 100, enums.RUB, deposit)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), got.Balance)
+	assert.Equal(t, int64(1), got.Version)
+
+	var stored models.Transaction
+	assert.NoError(t, db.First(&stored, "id = ?", deposit.ID).Error)
+	assert.Equal(t, wallet.ID, stored.WalletID)
+	assert.Equal(t, int64(0), stored.BalanceBefore)
+	assert.Equal(t, int64(100), stored.BalanceAfter)
+	assert.Equal(t, enums.RUB, stored.Currency)
+	assert.Equal(t, stored.BalanceAfter, deposit.BalanceAfter)
+
+	_, err = repo.AdjustBalance(context.Background(), wallet.ID, 100, "", &models.Transaction{OperationType: enums.DEPOSIT, Amount: 100, IdempotencyKey: &key})
+	assert.ErrorIs(t, err, repository.ErrDuplicateIdempotencyKey)
+
+	_, err = repo.CreateHold(context.Background(), &models.Hold{WalletID: wallet.ID, Amount: 70, Status: enums.HoldActive, ExpiresAt: time.Now().Add(time.Minute)}, nil, func(w *models.Wallet) error {
+		w.Held += 70
+		return nil
+	})
+	assert.NoError(t, err)
+
+	_, err = repo.AdjustBalance(context.Background(), wallet.ID, -50, "", &models.Transaction{OperationType: enums.WITHDRAW, Amount: 50})
+	assert.ErrorIs(t, err, repository.ErrInsufficientFunds)
+
+	_, err = repo.AdjustBalance(context.Background(), wallet.ID, -30, enums.USD, &models.Transaction{OperationType: enums.WITHDRAW, Amount: 30})
+	assert.ErrorIs(t, err, repository.ErrCurrencyMismatch)
+
+	_, err = repo.AdjustBalance(context.Background(), uuid.New(), 10, "", &models.Transaction{OperationType: enums.DEPOSIT, Amount: 10})
+	assert.ErrorIs(t, err, repository.ErrWalletNotFound)
+
+	got, err = repo.AdjustBalance(context.Background(), wallet.ID, -30, "", &models.Transaction{OperationType: enums.WITHDRAW, Amount: 30})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(70), got.Balance)
+	assert.Equal(t, int64(0), got.Available())
+
+	var count int64
+	assert.NoError(t, db.Model(&models.Transaction{}).Where("wallet_id = ?", wallet.ID).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestWalletRepository_BatchAtomic(t *testing.T) {
+	db := setupTestDB(t)
+	repo := &repository.WalletGORMRepository{DB: db}
+
+	a, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+	b, err := repo.Create(context.Background(), enums.RUB)
+	assert.NoError(t, err)
+
+	deposit := func(amount int64) func(w, _ *models.Wallet) error {
+		return func(w, _ *models.Wallet) error {
+			w.Balance += amount
+			return nil
+		}
+	}
+
+	err = repo.BatchAtomic(context.Background(), []repository.BatchStep{
+		{WalletID: a.ID, Record: &models.Transaction{OperationType: enums.DEPOSIT, Amount: 50}, Apply: deposit(50)},
+		{WalletID: a.ID, ToWalletID: &b.ID, Record: &models.Transaction{OperationType: enums.TRANSFER, Amount: 20}, Apply: func(w, to *models.Wallet) error {
+			w.Balance -= 20
+			to.Balance += 20
+			return nil
+		}},
+	})
+	assert.NoError(t, err)
+
+	gotA, err := repo.Get(context.Background(), a.ID)
+	assert.NoError(t, err)
+	gotB, err := repo.Get(context.Background(), b.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), gotA.Balance)
+	assert.Equal(t, int64(20), gotB.Balance)
+
+	var count int64
+	assert.NoError(t, db.Model(&models.Transaction{}).Where("wallet_id IN ?", []any{a.ID, b.ID}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+
+	err = repo.BatchAtomic(context.Background(), []repository.BatchStep{
+		{WalletID: a.ID, Record: &models.Transaction{OperationType: enums.DEPOSIT, Amount: 5}, Apply: deposit(5)},
+		{WalletID: uuid.New(), Record: &models.Transaction{OperationType: enums.DEPOSIT, Amount: 5}, Apply: deposit(5)},
+	})
+	var stepErr *repository.BatchStepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, 1, stepErr.Index)
+	assert.ErrorIs(t, err, repository.ErrWalletNotFound)
+
+	gotA, err = repo.Get(context.Background(), a.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), gotA.Balance)
+}
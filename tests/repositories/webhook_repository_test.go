@@ -0,0 +1,124 @@
+package repositories_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSubscription(t *testing.T, repo *repository.WebhookGORMRepository, active bool, eventTypes ...string) models.WebhookSubscription {
+	t.Helper()
+	now := time.Now()
+	sub := models.WebhookSubscription{
+		ID:         uuid.New(),
+		URL:        "https://example.com/hook",
+		Secret:     "secret",
+		EventTypes: eventTypes,
+		Active:     active,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	require.NoError(t, repo.CreateSubscription(context.Background(), &sub))
+	return sub
+}
+
+func newDelivery(sub models.WebhookSubscription, eventID uuid.UUID, due time.Time) models.WebhookDelivery {
+	return models.WebhookDelivery{
+		ID:             uuid.New(),
+		SubscriptionID: sub.ID,
+		EventID:        eventID,
+		EventType:      models.EventWalletCreated,
+		Body:           json.RawMessage(`{"id":"` + eventID.String() + `"}`),
+		Status:         enums.DeliveryPending,
+		NextAttemptAt:  due,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+}
+
+func TestWebhooks_SubscriptionsFor(t *testing.T) {
+	repo := &repository.WebhookGORMRepository{DB: setupTestDB(t)}
+	ctx := context.Background()
+
+	credited := newSubscription(t, repo, true, models.EventWalletCredited, models.EventWalletDebited)
+	created := newSubscription(t, repo, true, models.EventWalletCreated)
+	inactive := newSubscription(t, repo, false, models.EventWalletCredited)
+
+	subs, err := repo.SubscriptionsFor(ctx, models.EventWalletCredited)
+	require.NoError(t, err)
+	found := make(map[uuid.UUID]models.WebhookSubscription)
+	for _, sub := range subs {
+		found[sub.ID] = sub
+	}
+	assert.NotContains(t, found, created.ID, "uninterested subscriptions are skipped")
+	assert.NotContains(t, found, inactive.ID, "inactive subscriptions are skipped")
+	require.Contains(t, found, credited.ID)
+	assert.Equal(t, models.EventTypes{models.EventWalletCredited, models.EventWalletDebited}, found[credited.ID].EventTypes)
+
+	require.NoError(t, repo.DeleteSubscription(ctx, credited.ID))
+	_, err = repo.GetSubscription(ctx, credited.ID)
+	assert.ErrorIs(t, err, repository.ErrSubscriptionNotFound)
+	assert.ErrorIs(t, repo.DeleteSubscription(ctx, credited.ID), repository.ErrSubscriptionNotFound)
+}
+
+func TestWebhooks_EnqueueAndClaimDeliveries(t *testing.T) {
+	repo := &repository.WebhookGORMRepository{DB: setupTestDB(t)}
+	ctx := context.Background()
+	now := time.Now()
+
+	sub := newSubscription(t, repo, true, models.EventWalletCreated)
+	eventID := uuid.New()
+	due := newDelivery(sub, eventID, now.Add(-time.Minute))
+	later := newDelivery(sub, uuid.New(), now.Add(time.Hour))
+	require.NoError(t, repo.EnqueueDeliveries(ctx, []models.WebhookDelivery{due, later}))
+
+	// The same event queued again for the subscription is skipped.
+	require.NoError(t, repo.EnqueueDeliveries(ctx, []models.WebhookDelivery{newDelivery(sub, eventID, now)}))
+
+	all, err := repo.Deliveries(ctx, repository.DeliveryFilter{SubscriptionID: sub.ID})
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	// No other test queues deliveries, so the claims below only see the
+	// ones of sub.
+	claimed, err := repo.ClaimDeliveries(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, due.ID, claimed[0].ID)
+	assert.JSONEq(t, string(due.Body), string(claimed[0].Body))
+
+	claimed, err = repo.ClaimDeliveries(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed, "leased deliveries are not claimed twice")
+
+	claimed, err = repo.ClaimDeliveries(ctx, now.Add(2*time.Minute), now.Add(3*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 1, "an expired lease is claimed again")
+
+	delivery := claimed[0]
+	delivery.Status = enums.DeliveryDead
+	delivery.Attempts = 3
+	require.NoError(t, repo.SaveDelivery(ctx, &delivery))
+
+	dead, err := repo.Deliveries(ctx, repository.DeliveryFilter{SubscriptionID: sub.ID, Status: enums.DeliveryDead})
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 3, dead[0].Attempts)
+
+	_, err = repo.GetDelivery(ctx, uuid.New(), delivery.ID)
+	assert.ErrorIs(t, err, repository.ErrDeliveryNotFound)
+
+	// Deleting the subscription deletes its delivery log.
+	require.NoError(t, repo.DeleteSubscription(ctx, sub.ID))
+	_, err = repo.GetDelivery(ctx, sub.ID, delivery.ID)
+	assert.ErrorIs(t, err, repository.ErrDeliveryNotFound)
+}
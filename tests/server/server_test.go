@@ -0,0 +1,132 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"itk-academy-test/config"
+	"itk-academy-test/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+	return addr
+}
+
+func waitReady(t *testing.T, srv *server.Server) {
+	t.Helper()
+	require.Eventually(t, srv.Ready, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServer_DrainsInFlightRequests(t *testing.T) {
+	addr := freeAddr(t)
+	started := make(chan struct{})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		_, _ = io.WriteString(w, "done")
+	})
+
+	srv := server.New(handler, config.ServerConfig{
+		Addr:            addr,
+		ReadTimeout:     time.Second,
+		WriteTimeout:    time.Second,
+		IdleTimeout:     time.Second,
+		ShutdownTimeout: 2 * time.Second,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- srv.Run(ctx) }()
+	waitReady(t, srv)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resCh <- result{body: string(body), err: err}
+	}()
+
+	<-started
+	cancel()
+
+	require.Eventually(t, func() bool { return !srv.Ready() }, time.Second, 5*time.Millisecond)
+
+	res := <-resCh
+	assert.NoError(t, res.err)
+	assert.Equal(t, "done", res.body)
+	assert.NoError(t, <-runErr)
+}
+
+func TestServer_ReportsNotReadyBeforeClosing(t *testing.T) {
+	addr := freeAddr(t)
+
+	var srv *server.Server
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !srv.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+
+	srv = server.New(handler, config.ServerConfig{
+		Addr:            addr,
+		ReadTimeout:     time.Second,
+		WriteTimeout:    time.Second,
+		IdleTimeout:     time.Second,
+		DrainDelay:      500 * time.Millisecond,
+		ShutdownTimeout: time.Second,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- srv.Run(ctx) }()
+	waitReady(t, srv)
+
+	readyz := func() int {
+		resp, err := http.Get("http://" + addr + "/readyz")
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, readyz())
+
+	cancel()
+	require.Eventually(t, func() bool { return !srv.Ready() }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, readyz(), "the server keeps serving during the drain delay")
+
+	assert.NoError(t, <-runErr)
+	_, err := http.Get("http://" + addr + "/readyz")
+	assert.Error(t, err, "the server stops accepting connections after the drain delay")
+}
+
+func TestServer_RunFailsOnBusyAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	srv := server.New(http.NotFoundHandler(), config.ServerConfig{Addr: l.Addr().String(), ShutdownTimeout: time.Second})
+
+	assert.Error(t, srv.Run(context.Background()))
+	assert.False(t, srv.Ready())
+}
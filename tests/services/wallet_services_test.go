@@ -1,56 +1,159 @@
 package services_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync"
 	"testing"
+	"time"
 
 	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/dto"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/logging"
+	"itk-academy-test/internal/metrics"
 	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
 	"itk-academy-test/internal/services"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 type mockWalletRepo struct {
-	createFn        func() (models.Wallet, error)
+	createFn        func(currency enums.Currency) (models.Wallet, error)
 	updateFn        func(*models.Wallet) (*models.Wallet, error)
 	deleteFn        func(id uuid.UUID) error
 	getFn           func(id uuid.UUID) (*models.Wallet, error)
-	allWalletsFn    func() (*[]models.Wallet, error)
-	operateAtomicFn func(id uuid.UUID, fn func(*models.Wallet) error) (*models.Wallet, error)
+	listFn          func(filter repository.WalletFilter) ([]models.Wallet, int64, error)
+	operateAtomicFn func(id uuid.UUID, record *models.Transaction, fn func(*models.Wallet) error) (*models.Wallet, error)
+	adjustBalanceFn func(id uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (*models.Wallet, error)
+	transactionsFn  func(filter repository.TransactionFilter) ([]models.Transaction, error)
+	byKeyFn         func(key string) (*models.Transaction, error)
+	transferFn      func(from, to uuid.UUID, record *models.Transaction, fn func(from, to *models.Wallet) error) (*models.Wallet, *models.Wallet, error)
+	getHoldFn       func(id uuid.UUID) (*models.Hold, error)
+	createHoldFn    func(hold *models.Hold, record *models.Transaction, fn func(w *models.Wallet) error) (*models.Wallet, error)
+	operateHoldFn   func(id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet, h *models.Hold) error) (*models.Wallet, *models.Hold, error)
+	expiredHoldsFn  func(now time.Time, limit int) ([]models.Hold, error)
+	batchAtomicFn   func(steps []repository.BatchStep) error
+	reconcileFn     func() (*repository.ReconciliationReport, error)
+	adjustLedgerFn  func(walletID uuid.UUID) (*models.JournalEntry, error)
+	pendingEvents   []models.OutboxEvent
 }
 
-func (m *mockWalletRepo) Create() (models.Wallet, error) {
-	return m.createFn()
+func (m *mockWalletRepo) Create(_ context.Context, currency enums.Currency) (models.Wallet, error) {
+	return m.createFn(currency)
 }
-func (m *mockWalletRepo) Update(w *models.Wallet) (*models.Wallet, error) {
+func (m *mockWalletRepo) Update(_ context.Context, w *models.Wallet) (*models.Wallet, error) {
 	return m.updateFn(w)
 }
-func (m *mockWalletRepo) Delete(id uuid.UUID) error {
+func (m *mockWalletRepo) Delete(_ context.Context, id uuid.UUID) error {
 	return m.deleteFn(id)
 }
-func (m *mockWalletRepo) Get(id uuid.UUID) (*models.Wallet, error) {
+func (m *mockWalletRepo) Get(_ context.Context, id uuid.UUID) (*models.Wallet, error) {
 	return m.getFn(id)
 }
-func (m *mockWalletRepo) OperateAtomic(id uuid.UUID, fn func(*models.Wallet) error) (*models.Wallet, error) {
-	return m.operateAtomicFn(id, fn)
+func (m *mockWalletRepo) OperateAtomic(_ context.Context, id uuid.UUID, record *models.Transaction, fn func(*models.Wallet) error) (*models.Wallet, error) {
+	return m.operateAtomicFn(id, record, fn)
 }
-func (m *mockWalletRepo) AllWallets() (*[]models.Wallet, error) {
-	return m.allWalletsFn()
+func (m *mockWalletRepo) AdjustBalance(_ context.Context, id uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (*models.Wallet, error) {
+	return m.adjustBalanceFn(id, delta, currency, record)
+}
+func (m *mockWalletRepo) List(_ context.Context, filter repository.WalletFilter) ([]models.Wallet, int64, error) {
+	return m.listFn(filter)
+}
+func (m *mockWalletRepo) Transactions(_ context.Context, filter repository.TransactionFilter) ([]models.Transaction, error) {
+	return m.transactionsFn(filter)
+}
+func (m *mockWalletRepo) TransactionByIdempotencyKey(_ context.Context, key string) (*models.Transaction, error) {
+	return m.byKeyFn(key)
+}
+func (m *mockWalletRepo) GetHold(_ context.Context, id uuid.UUID) (*models.Hold, error) {
+	return m.getHoldFn(id)
+}
+func (m *mockWalletRepo) CreateHold(_ context.Context, hold *models.Hold, record *models.Transaction, fn func(w *models.Wallet) error) (*models.Wallet, error) {
+	return m.createHoldFn(hold, record, fn)
+}
+func (m *mockWalletRepo) OperateHold(_ context.Context, id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet, h *models.Hold) error) (*models.Wallet, *models.Hold, error) {
+	return m.operateHoldFn(id, record, fn)
+}
+func (m *mockWalletRepo) ExpiredHolds(_ context.Context, now time.Time, limit int) ([]models.Hold, error) {
+	return m.expiredHoldsFn(now, limit)
+}
+func (m *mockWalletRepo) BatchAtomic(_ context.Context, steps []repository.BatchStep) error {
+	return m.batchAtomicFn(steps)
+}
+func (m *mockWalletRepo) Reconcile(context.Context) (*repository.ReconciliationReport, error) {
+	return m.reconcileFn()
+}
+func (m *mockWalletRepo) AdjustLedger(_ context.Context, walletID uuid.UUID) (*models.JournalEntry, error) {
+	return m.adjustLedgerFn(walletID)
+}
+func (m *mockWalletRepo) PublishEvents(ctx context.Context, limit int, publish func(context.Context, models.OutboxEvent) error) (int, error) {
+	published := 0
+	for published < limit && published < len(m.pendingEvents) {
+		if err := publish(ctx, m.pendingEvents[published]); err != nil {
+			m.pendingEvents = m.pendingEvents[published:]
+			return published, err
+		}
+		published++
+	}
+	m.pendingEvents = m.pendingEvents[published:]
+	return published, nil
+}
+func (m *mockWalletRepo) TransferAtomic(_ context.Context, from, to uuid.UUID, record *models.Transaction, fn func(from, to *models.Wallet) error) (*models.Wallet, *models.Wallet, error) {
+	return m.transferFn(from, to, record, fn)
+}
+
+// adjustWallet applies deltas to copies of w the way the AdjustBalance
+// statement does, rejecting a wrong currency and uncovered withdrawals.
+func adjustWallet(w models.Wallet) func(uuid.UUID, int64, enums.Currency, *models.Transaction) (*models.Wallet, error) {
+	return func(_ uuid.UUID, delta int64, currency enums.Currency, _ *models.Transaction) (*models.Wallet, error) {
+		if currency != "" && w.Currency != currency {
+			return nil, repository.ErrCurrencyMismatch
+		}
+		if w.Available()+delta < 0 {
+			return nil, repository.ErrInsufficientFunds
+		}
+		adjusted := w
+		adjusted.Balance += delta
+		return &adjusted, nil
+	}
 }
 
 func TestWalletService_Create(t *testing.T) {
 	id := uuid.New()
 	mockRepo := &mockWalletRepo{
-		createFn: func() (models.Wallet, error) {
-			return models.Wallet{ID: id, Balance: 0}, nil
+		createFn: func(currency enums.Currency) (models.Wallet, error) {
+			return models.Wallet{ID: id, Balance: 0, Currency: currency}, nil
 		},
 	}
 	service := services.New(mockRepo)
 
-	w, err := service.Create()
+	w, err := service.Create(context.Background(), enums.USD)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, w.Balance)
+	assert.Equal(t, int64(0), w.Balance)
+	assert.Equal(t, enums.USD, w.Currency)
+
+	w, err = service.Create(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, enums.DefaultCurrency, w.Currency)
+}
+
+func TestWalletService_Create_UnsupportedCurrency(t *testing.T) {
+	service := services.New(&mockWalletRepo{})
+
+	_, err := service.Create(context.Background(), "JPY")
+	assert.ErrorIs(t, err, services.ErrUnsupportedCurrency)
 }
 
 func TestWalletService_Amount(t *testing.T) {
@@ -63,29 +166,28 @@ func TestWalletService_Amount(t *testing.T) {
 	}
 	service := services.New(mockRepo)
 
-	amount, err := service.Amount(id)
+	amount, err := service.Amount(context.Background(), id)
 	assert.NoError(t, err)
-	assert.Equal(t, 100, amount)
+	assert.Equal(t, int64(100), amount)
 }
 
 func TestWalletService_Operation_Deposit(t *testing.T) {
 	id := uuid.New()
 
 	mockRepo := &mockWalletRepo{
-		operateAtomicFn: func(got uuid.UUID, fn func(*models.Wallet) error) (*models.Wallet, error) {
+		adjustBalanceFn: func(got uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (*models.Wallet, error) {
 			assert.Equal(t, id, got)
-			w := &models.Wallet{ID: id, Balance: 100}
-			if err := fn(w); err != nil {
-				return nil, err
-			}
-			return w, nil
+			assert.Equal(t, int64(50), delta)
+			assert.Equal(t, enums.DEPOSIT, record.OperationType)
+			assert.Equal(t, int64(50), record.Amount)
+			return adjustWallet(models.Wallet{ID: id, Balance: 100})(got, delta, currency, record)
 		},
 	}
 	svc := services.New(mockRepo)
 
-	w, err := svc.Operation(id, enums.DEPOSIT, 50)
+	w, err := svc.Operation(context.Background(), id, enums.DEPOSIT, 50, "")
 	assert.NoError(t, err)
-	assert.Equal(t, 150, w.Balance)
+	assert.Equal(t, int64(150), w.Balance)
 	assert.Equal(t, id, w.ID)
 }
 
@@ -93,20 +195,18 @@ func TestWalletService_Operation_Withdraw_Success(t *testing.T) {
 	id := uuid.New()
 
 	mockRepo := &mockWalletRepo{
-		operateAtomicFn: func(got uuid.UUID, fn func(*models.Wallet) error) (*models.Wallet, error) {
+		adjustBalanceFn: func(got uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (*models.Wallet, error) {
 			assert.Equal(t, id, got)
-			w := &models.Wallet{ID: id, Balance: 100}
-			if err := fn(w); err != nil {
-				return nil, err
-			}
-			return w, nil
+			assert.Equal(t, int64(-50), delta)
+			assert.Equal(t, enums.WITHDRAW, record.OperationType)
+			return adjustWallet(models.Wallet{ID: id, Balance: 100})(got, delta, currency, record)
 		},
 	}
 	svc := services.New(mockRepo)
 
-	w, err := svc.Operation(id, enums.WITHDRAW, 50)
+	w, err := svc.Operation(context.Background(), id, enums.WITHDRAW, 50, "")
 	assert.NoError(t, err)
-	assert.Equal(t, 50, w.Balance)
+	assert.Equal(t, int64(50), w.Balance)
 	assert.Equal(t, id, w.ID)
 }
 
@@ -114,29 +214,308 @@ func TestWalletService_Operation_Withdraw_InsufficientFunds(t *testing.T) {
 	id := uuid.New()
 
 	mockRepo := &mockWalletRepo{
-		operateAtomicFn: func(got uuid.UUID, fn func(*models.Wallet) error) (*models.Wallet, error) {
-			assert.Equal(t, id, got)
-			w := &models.Wallet{ID: id, Balance: 30}
-			if err := fn(w); err != nil {
-				return nil, err
+		adjustBalanceFn: adjustWallet(models.Wallet{ID: id, Balance: 30}),
+	}
+	svc := services.New(mockRepo)
+
+	w, err := svc.Operation(context.Background(), id, enums.WITHDRAW, 50, "")
+	assert.Nil(t, w)
+	assert.EqualError(t, err, "Insufficient funds")
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+}
+
+func TestWalletService_Operation_InvalidType(t *testing.T) {
+	svc := services.New(&mockWalletRepo{})
+
+	w, err := svc.Operation(context.Background(), uuid.New(), "HELLO", 10, "")
+	assert.Nil(t, w)
+	assert.ErrorIs(t, err, services.ErrInvalidOperation)
+}
+
+func TestWalletService_Transactions_Pagination(t *testing.T) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	history := []models.Transaction{
+		{ID: uuid.New(), WalletID: id, OperationType: enums.DEPOSIT, Amount: 30, CreatedAt: now},
+		{ID: uuid.New(), WalletID: id, OperationType: enums.DEPOSIT, Amount: 20, CreatedAt: now.Add(-time.Second)},
+		{ID: uuid.New(), WalletID: id, OperationType: enums.DEPOSIT, Amount: 10, CreatedAt: now.Add(-2 * time.Second)},
+	}
+
+	mockRepo := &mockWalletRepo{
+		getFn: func(got uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: got}, nil
+		},
+		transactionsFn: func(filter repository.TransactionFilter) ([]models.Transaction, error) {
+			assert.Equal(t, id, filter.WalletID)
+			start := 0
+			if filter.AfterCreatedAt != nil {
+				for i, tr := range history {
+					if tr.ID == filter.AfterID {
+						start = i + 1
+					}
+				}
 			}
-			return w, nil
+			end := min(start+filter.Limit, len(history))
+			return history[start:end], nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	page, next, err := svc.Transactions(context.Background(), id, dto.TransactionHistoryQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.NotEmpty(t, next)
+
+	page, next, err = svc.Transactions(context.Background(), id, dto.TransactionHistoryQuery{Limit: 2, Cursor: next})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, history[2].ID, page[0].ID)
+	assert.Empty(t, next)
+}
+
+func TestWalletService_Transactions_InvalidCursor(t *testing.T) {
+	mockRepo := &mockWalletRepo{
+		getFn: func(got uuid.UUID) (*models.Wallet, error) {
+			return &models.Wallet{ID: got}, nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	_, _, err := svc.Transactions(context.Background(), uuid.New(), dto.TransactionHistoryQuery{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, services.ErrInvalidCursor)
+}
+
+func TestWalletService_OperationWithKey_Replay(t *testing.T) {
+	id := uuid.New()
+	key := "key-1"
+
+	mockRepo := &mockWalletRepo{
+		byKeyFn: func(got string) (*models.Transaction, error) {
+			assert.Equal(t, key, got)
+			return &models.Transaction{WalletID: id, OperationType: enums.DEPOSIT, Amount: 50, BalanceAfter: 150, IdempotencyKey: &key}, nil
+		},
+		adjustBalanceFn: func(uuid.UUID, int64, enums.Currency, *models.Transaction) (*models.Wallet, error) {
+			t.Fatal("replayed operation must not touch the balance")
+			return nil, nil
 		},
 	}
 	svc := services.New(mockRepo)
 
-	w, err := svc.Operation(id, enums.WITHDRAW, 50)
+	w, replayed, err := svc.OperationWithKey(context.Background(), key, id, enums.DEPOSIT, 50, "")
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, int64(150), w.Balance)
+}
+
+func TestWalletService_OperationWithKey_Conflict(t *testing.T) {
+	id := uuid.New()
+	key := "key-1"
+
+	mockRepo := &mockWalletRepo{
+		byKeyFn: func(string) (*models.Transaction, error) {
+			return &models.Transaction{WalletID: id, OperationType: enums.DEPOSIT, Amount: 50, BalanceAfter: 150, IdempotencyKey: &key}, nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	w, _, err := svc.OperationWithKey(context.Background(), key, id, enums.DEPOSIT, 60, "")
 	assert.Nil(t, w)
+	assert.ErrorIs(t, err, services.ErrIdempotencyKeyReused)
+}
+
+func TestWalletService_OperationWithKey_ConcurrentDuplicate(t *testing.T) {
+	id := uuid.New()
+	key := "key-1"
+	calls := 0
+
+	mockRepo := &mockWalletRepo{
+		byKeyFn: func(string) (*models.Transaction, error) {
+			calls++
+			if calls == 1 {
+				return nil, nil
+			}
+			return &models.Transaction{WalletID: id, OperationType: enums.DEPOSIT, Amount: 50, BalanceAfter: 50, IdempotencyKey: &key}, nil
+		},
+		adjustBalanceFn: func(got uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (*models.Wallet, error) {
+			assert.Equal(t, key, *record.IdempotencyKey)
+			return nil, repository.ErrDuplicateIdempotencyKey
+		},
+	}
+	svc := services.New(mockRepo)
+
+	w, replayed, err := svc.OperationWithKey(context.Background(), key, id, enums.DEPOSIT, 50, "")
+	assert.NoError(t, err)
+	assert.True(t, replayed)
+	assert.Equal(t, int64(50), w.Balance)
+}
+
+func newTransferMock(t *testing.T, fromBalance, toBalance int64) *mockWalletRepo {
+	return &mockWalletRepo{
+		transferFn: func(from, to uuid.UUID, record *models.Transaction, fn func(from, to *models.Wallet) error) (*models.Wallet, *models.Wallet, error) {
+			assert.Equal(t, enums.TRANSFER, record.OperationType)
+			fw := &models.Wallet{ID: from, Balance: fromBalance}
+			tw := &models.Wallet{ID: to, Balance: toBalance}
+			if err := fn(fw, tw); err != nil {
+				return nil, nil, err
+			}
+			return fw, tw, nil
+		},
+	}
+}
+
+func TestWalletService_Transfer_Success(t *testing.T) {
+	svc := services.New(newTransferMock(t, 100, 10))
+
+	from, to, err := svc.Transfer(context.Background(), uuid.New(), uuid.New(), 40, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(60), from.Balance)
+	assert.Equal(t, int64(50), to.Balance)
+}
+
+func TestWalletService_Transfer_InsufficientFunds(t *testing.T) {
+	svc := services.New(newTransferMock(t, 30, 0))
+
+	from, to, err := svc.Transfer(context.Background(), uuid.New(), uuid.New(), 40, "")
+	assert.Nil(t, from)
+	assert.Nil(t, to)
 	assert.EqualError(t, err, "Insufficient funds")
 }
 
-func TestWalletService_Operation_InvalidType(t *testing.T) {
+func TestWalletService_Transfer_SameWallet(t *testing.T) {
+	svc := services.New(newTransferMock(t, 100, 100))
 	id := uuid.New()
 
+	_, _, err := svc.Transfer(context.Background(), id, id, 40, "")
+	assert.ErrorIs(t, err, services.ErrSameWallet)
+}
+
+func TestWalletService_Amount_NotFound(t *testing.T) {
 	mockRepo := &mockWalletRepo{
-		operateAtomicFn: func(got uuid.UUID, fn func(*models.Wallet) error) (*models.Wallet, error) {
-			assert.Equal(t, id, got)
-			w := &models.Wallet{ID: id, Balance: 100}
+		getFn: func(uuid.UUID) (*models.Wallet, error) {
+			return nil, repository.ErrWalletNotFound
+		},
+	}
+	service := services.New(mockRepo)
+
+	_, err := service.Amount(context.Background(), uuid.New())
+	assert.ErrorIs(t, err, services.ErrWalletNotFound)
+}
+
+func TestWalletService_Operation_InvalidAmount(t *testing.T) {
+	svc := services.New(&mockWalletRepo{})
+
+	_, err := svc.Operation(context.Background(), uuid.New(), enums.DEPOSIT, 0, "")
+	assert.ErrorIs(t, err, services.ErrInvalidAmount)
+}
+
+func TestWalletService_Operation_CurrencyMismatch(t *testing.T) {
+	id := uuid.New()
+
+	mockRepo := &mockWalletRepo{
+		adjustBalanceFn: adjustWallet(models.Wallet{ID: id, Balance: 100, Currency: enums.EUR}),
+	}
+	svc := services.New(mockRepo)
+
+	w, err := svc.Operation(context.Background(), id, enums.DEPOSIT, 50, enums.USD)
+	assert.Nil(t, w)
+	assert.ErrorIs(t, err, services.ErrCurrencyMismatch)
+
+	w, err = svc.Operation(context.Background(), id, enums.DEPOSIT, 50, enums.EUR)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150), w.Balance)
+}
+
+func TestWalletService_Transfer_CurrencyMismatch(t *testing.T) {
+	mockRepo := &mockWalletRepo{
+		transferFn: func(from, to uuid.UUID, record *models.Transaction, fn func(from, to *models.Wallet) error) (*models.Wallet, *models.Wallet, error) {
+			fw := &models.Wallet{ID: from, Balance: 100, Currency: enums.EUR}
+			tw := &models.Wallet{ID: to, Currency: enums.RUB}
+			if err := fn(fw, tw); err != nil {
+				return nil, nil, err
+			}
+			return fw, tw, nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	_, _, err := svc.Transfer(context.Background(), uuid.New(), uuid.New(), 10, "")
+	assert.ErrorIs(t, err, services.ErrCurrencyMismatch)
+}
+
+func TestWalletService_List_Pagination(t *testing.T) {
+	wallets := []models.Wallet{
+		{ID: uuid.New(), Balance: 30},
+		{ID: uuid.New(), Balance: 20},
+		{ID: uuid.New(), Balance: 10},
+	}
+
+	mockRepo := &mockWalletRepo{
+		listFn: func(filter repository.WalletFilter) ([]models.Wallet, int64, error) {
+			assert.Equal(t, repository.SortByBalance, filter.SortBy)
+			assert.True(t, filter.Desc)
+			start := 0
+			if filter.After != nil {
+				assert.Equal(t, int64(20), filter.After)
+				start = 2
+			}
+			end := min(start+filter.Limit, len(wallets))
+			return wallets[start:end], int64(len(wallets)), nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	page, next, total, err := svc.List(context.Background(), dto.WalletListQuery{SortBy: "balance", Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, int64(3), total)
+	assert.NotEmpty(t, next)
+
+	page, next, _, err = svc.List(context.Background(), dto.WalletListQuery{SortBy: "balance", Limit: 2, Cursor: next})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Empty(t, next)
+}
+
+func TestWalletService_List_CursorForDifferentSort(t *testing.T) {
+	mockRepo := &mockWalletRepo{
+		listFn: func(filter repository.WalletFilter) ([]models.Wallet, int64, error) {
+			return []models.Wallet{{ID: uuid.New()}, {ID: uuid.New()}}, 2, nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	_, next, _, err := svc.List(context.Background(), dto.WalletListQuery{Limit: 1})
+	assert.NoError(t, err)
+
+	_, _, _, err = svc.List(context.Background(), dto.WalletListQuery{SortBy: "balance", Cursor: next})
+	assert.ErrorIs(t, err, services.ErrInvalidCursor)
+}
+
+func TestWalletService_Operation_Withdraw_HonorsHolds(t *testing.T) {
+	id := uuid.New()
+
+	mockRepo := &mockWalletRepo{
+		adjustBalanceFn: adjustWallet(models.Wallet{ID: id, Balance: 100, Held: 70}),
+	}
+	svc := services.New(mockRepo)
+
+	_, err := svc.Operation(context.Background(), id, enums.WITHDRAW, 50, "")
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+
+	w, err := svc.Operation(context.Background(), id, enums.WITHDRAW, 30, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), w.Available())
+}
+
+func TestWalletService_CreateHold(t *testing.T) {
+	id := uuid.New()
+
+	mockRepo := &mockWalletRepo{
+		createHoldFn: func(hold *models.Hold, record *models.Transaction, fn func(w *models.Wallet) error) (*models.Wallet, error) {
+			assert.Equal(t, id, hold.WalletID)
+			assert.Equal(t, enums.HoldActive, hold.Status)
+			assert.Equal(t, enums.HOLD, record.OperationType)
+			w := &models.Wallet{ID: id, Balance: 100, Held: 20}
 			if err := fn(w); err != nil {
 				return nil, err
 			}
@@ -145,7 +524,472 @@ func TestWalletService_Operation_InvalidType(t *testing.T) {
 	}
 	svc := services.New(mockRepo)
 
-	w, err := svc.Operation(id, "HELLO", 10)
-	assert.Nil(t, w)
-	assert.EqualError(t, err, "Error")
+	hold, w, err := svc.CreateHold(context.Background(), id, 80, "", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(80), hold.Amount)
+	assert.Equal(t, int64(100), w.Balance)
+	assert.Equal(t, int64(0), w.Available())
+
+	_, _, err = svc.CreateHold(context.Background(), id, 81, "", time.Minute)
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+
+	_, _, err = svc.CreateHold(context.Background(), id, 10, "", -time.Minute)
+	assert.ErrorIs(t, err, services.ErrInvalidHoldTTL)
+}
+
+func newHoldMock(hold models.Hold, wallet models.Wallet) *mockWalletRepo {
+	return &mockWalletRepo{
+		operateHoldFn: func(id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet, h *models.Hold) error) (*models.Wallet, *models.Hold, error) {
+			h, w := hold, wallet
+			if err := fn(&w, &h); err != nil {
+				return nil, nil, err
+			}
+			return &w, &h, nil
+		},
+	}
+}
+
+func TestWalletService_CaptureHold_Partial(t *testing.T) {
+	hold := models.Hold{ID: uuid.New(), Amount: 80, Status: enums.HoldActive, ExpiresAt: time.Now().Add(time.Minute)}
+	svc := services.New(newHoldMock(hold, models.Wallet{Balance: 100, Held: 80}))
+
+	h, w, err := svc.CaptureHold(context.Background(), hold.ID, 50)
+	assert.NoError(t, err)
+	assert.Equal(t, enums.HoldCaptured, h.Status)
+	assert.Equal(t, int64(50), h.CapturedAmount)
+	assert.Equal(t, int64(50), w.Balance)
+	assert.Equal(t, int64(0), w.Held)
+
+	_, _, err = svc.CaptureHold(context.Background(), hold.ID, 90)
+	assert.ErrorIs(t, err, services.ErrCaptureExceedsHold)
+}
+
+func TestWalletService_CaptureHold_Expired(t *testing.T) {
+	hold := models.Hold{ID: uuid.New(), Amount: 80, Status: enums.HoldActive, ExpiresAt: time.Now().Add(-time.Minute)}
+	svc := services.New(newHoldMock(hold, models.Wallet{Balance: 100, Held: 80}))
+
+	_, _, err := svc.CaptureHold(context.Background(), hold.ID, 0)
+	assert.ErrorIs(t, err, services.ErrHoldExpired)
+}
+
+func TestWalletService_ReleaseHold(t *testing.T) {
+	hold := models.Hold{ID: uuid.New(), Amount: 80, Status: enums.HoldActive, ExpiresAt: time.Now().Add(time.Minute)}
+	svc := services.New(newHoldMock(hold, models.Wallet{Balance: 100, Held: 80}))
+
+	h, w, err := svc.ReleaseHold(context.Background(), hold.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, enums.HoldReleased, h.Status)
+	assert.Equal(t, int64(100), w.Available())
+
+	hold.Status = enums.HoldReleased
+	svc = services.New(newHoldMock(hold, models.Wallet{Balance: 100}))
+
+	_, _, err = svc.ReleaseHold(context.Background(), hold.ID)
+	assert.ErrorIs(t, err, services.ErrHoldNotActive)
+}
+
+func TestWalletService_ExpireHolds(t *testing.T) {
+	now := time.Now()
+	expired := models.Hold{ID: uuid.New(), Amount: 30, Status: enums.HoldActive, ExpiresAt: now.Add(-time.Minute)}
+	captured := models.Hold{ID: uuid.New(), Amount: 20, Status: enums.HoldActive, ExpiresAt: now.Add(-time.Minute)}
+
+	var records []*models.Transaction
+	mockRepo := &mockWalletRepo{
+		expiredHoldsFn: func(got time.Time, limit int) ([]models.Hold, error) {
+			assert.Equal(t, now, got)
+			assert.Equal(t, 10, limit)
+			return []models.Hold{expired, captured}, nil
+		},
+		operateHoldFn: func(id uuid.UUID, record *models.Transaction, fn func(w *models.Wallet, h *models.Hold) error) (*models.Wallet, *models.Hold, error) {
+			h := expired
+			if id == captured.ID {
+				// Captured between listing and locking.
+				h = captured
+				h.Status = enums.HoldCaptured
+			}
+			w := &models.Wallet{Balance: 100, Held: 50}
+			if err := fn(w, &h); err != nil {
+				return nil, nil, err
+			}
+			assert.Equal(t, enums.HoldExpired, h.Status)
+			assert.Equal(t, int64(20), w.Held)
+			records = append(records, record)
+			return w, &h, nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	n, err := svc.ExpireHolds(context.Background(), now, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, records, 1)
+	assert.Equal(t, enums.RELEASE, records[0].OperationType)
+	assert.Equal(t, int64(30), records[0].Amount)
+}
+
+func TestWalletService_Operation_CountsOutcomes(t *testing.T) {
+	id := uuid.New()
+	mockRepo := &mockWalletRepo{
+		adjustBalanceFn: adjustWallet(models.Wallet{ID: id, Balance: 30}),
+	}
+	svc := services.New(mockRepo)
+
+	success := metrics.Operations.WithLabelValues(string(enums.WITHDRAW), "success")
+	insufficient := metrics.Operations.WithLabelValues(string(enums.WITHDRAW), "insufficient_funds")
+	successBefore, insufficientBefore := testutil.ToFloat64(success), testutil.ToFloat64(insufficient)
+
+	_, err := svc.Operation(context.Background(), id, enums.WITHDRAW, 10, "")
+	assert.NoError(t, err)
+	_, err = svc.Operation(context.Background(), id, enums.WITHDRAW, 50, "")
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+
+	assert.Equal(t, successBefore+1, testutil.ToFloat64(success))
+	assert.Equal(t, insufficientBefore+1, testutil.ToFloat64(insufficient))
+}
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestWalletService_Operation_Span(t *testing.T) {
+	recorder := recordSpans(t)
+
+	id := uuid.New()
+	mockRepo := &mockWalletRepo{
+		adjustBalanceFn: adjustWallet(models.Wallet{ID: id, Balance: 30}),
+	}
+	svc := services.New(mockRepo)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	_, err := svc.Operation(ctx, id, enums.WITHDRAW, 50, "")
+	parent.End()
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+
+	span := spans[0]
+	assert.Equal(t, "WalletService.Operation", span.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Contains(t, span.Attributes(), attribute.String("wallet.id", id.String()))
+	assert.Contains(t, span.Attributes(), attribute.String("wallet.operation", "WITHDRAW"))
+}
+
+func TestWalletService_Operation_LogsOutcome(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, slog.LevelInfo).With("request_id", "req-1")
+	ctx := logging.WithLogger(context.Background(), logger)
+
+	id := uuid.New()
+	mockRepo := &mockWalletRepo{
+		adjustBalanceFn: adjustWallet(models.Wallet{ID: id, Balance: 30}),
+	}
+	svc := services.New(mockRepo)
+
+	_, err := svc.Operation(ctx, id, enums.WITHDRAW, 50, "")
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Equal(t, id.String(), line["wallet_id"])
+	assert.Equal(t, "WITHDRAW", line["operation"])
+	assert.Equal(t, "insufficient_funds", line["outcome"])
+	assert.Equal(t, "WARN", line["level"])
+}
+
+func TestWalletService_Operation_ConstraintViolation(t *testing.T) {
+	mockRepo := &mockWalletRepo{
+		adjustBalanceFn: func(uuid.UUID, int64, enums.Currency, *models.Transaction) (*models.Wallet, error) {
+			// The DB CHECK constraint rejected the update.
+			return nil, repository.ErrInsufficientFunds
+		},
+	}
+	svc := services.New(mockRepo)
+
+	_, err := svc.Operation(context.Background(), uuid.New(), enums.WITHDRAW, 10, "")
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+}
+
+// applySteps runs steps against wallets the way BatchAtomic does, stopping
+// at the first failing step.
+func applySteps(wallets map[uuid.UUID]*models.Wallet, steps []repository.BatchStep) error {
+	for i, step := range steps {
+		var to *models.Wallet
+		if step.ToWalletID != nil {
+			to = wallets[*step.ToWalletID]
+		}
+		err := step.Apply(wallets[step.WalletID], to)
+		if err != nil && !errors.Is(err, repository.ErrSkipStep) {
+			return &repository.BatchStepError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+func TestWalletService_Batch_Atomic(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	mockRepo := &mockWalletRepo{
+		batchAtomicFn: func(steps []repository.BatchStep) error {
+			assert.Len(t, steps, 3)
+			assert.Equal(t, enums.TRANSFER, steps[1].Record.OperationType)
+			assert.Equal(t, "payroll-2", *steps[1].Record.IdempotencyKey)
+			return applySteps(map[uuid.UUID]*models.Wallet{
+				a: {ID: a, Currency: enums.RUB},
+				b: {ID: b, Currency: enums.RUB},
+			}, steps)
+		},
+	}
+	svc := services.New(mockRepo)
+
+	results, err := svc.Batch(context.Background(), []services.BatchItem{
+		{WalletID: a, OperationType: enums.DEPOSIT, Amount: 100},
+		{WalletID: a, OperationType: enums.TRANSFER, Amount: 40, ToWalletID: &b, IdempotencyKey: "payroll-2"},
+		{WalletID: b, OperationType: enums.WITHDRAW, Amount: 10},
+	}, true)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, int64(100), results[0].Wallet.Balance)
+	assert.Equal(t, int64(60), results[1].Wallet.Balance)
+	assert.Equal(t, int64(30), results[2].Wallet.Balance)
+}
+
+func TestWalletService_Batch_Atomic_StepFails(t *testing.T) {
+	a := uuid.New()
+	mockRepo := &mockWalletRepo{
+		batchAtomicFn: func(steps []repository.BatchStep) error {
+			return applySteps(map[uuid.UUID]*models.Wallet{a: {ID: a}}, steps)
+		},
+	}
+	svc := services.New(mockRepo)
+
+	results, err := svc.Batch(context.Background(), []services.BatchItem{
+		{WalletID: a, OperationType: enums.DEPOSIT, Amount: 100},
+		{WalletID: a, OperationType: enums.WITHDRAW, Amount: 150},
+		{WalletID: a, OperationType: enums.DEPOSIT, Amount: 1},
+	}, true)
+	assert.ErrorIs(t, err, services.ErrInsufficientFunds)
+	assert.Len(t, results, 3)
+	assert.ErrorIs(t, results[0].Err, services.ErrBatchRolledBack)
+	assert.Nil(t, results[0].Wallet)
+	assert.ErrorIs(t, results[1].Err, services.ErrInsufficientFunds)
+	assert.ErrorIs(t, results[2].Err, services.ErrBatchRolledBack)
+}
+
+func TestWalletService_Batch_Atomic_InvalidItem(t *testing.T) {
+	svc := services.New(&mockWalletRepo{})
+	a := uuid.New()
+
+	results, err := svc.Batch(context.Background(), []services.BatchItem{
+		{WalletID: a, OperationType: enums.DEPOSIT, Amount: 100},
+		{WalletID: a, OperationType: enums.TRANSFER, Amount: 10, ToWalletID: &a},
+	}, true)
+	assert.ErrorIs(t, err, services.ErrSameWallet)
+	assert.ErrorIs(t, results[0].Err, services.ErrBatchRolledBack)
+	assert.ErrorIs(t, results[1].Err, services.ErrSameWallet)
+}
+
+func TestWalletService_Batch_Atomic_ReusedKey(t *testing.T) {
+	mockRepo := &mockWalletRepo{
+		batchAtomicFn: func(steps []repository.BatchStep) error {
+			return &repository.BatchStepError{Index: 0, Err: repository.ErrDuplicateIdempotencyKey}
+		},
+	}
+	svc := services.New(mockRepo)
+
+	_, err := svc.Batch(context.Background(), []services.BatchItem{
+		{WalletID: uuid.New(), OperationType: enums.DEPOSIT, Amount: 100, IdempotencyKey: "used"},
+	}, true)
+	assert.ErrorIs(t, err, services.ErrIdempotencyKeyReused)
+}
+
+func TestWalletService_Batch_BestEffort(t *testing.T) {
+	a, missing := uuid.New(), uuid.New()
+	mockRepo := &mockWalletRepo{
+		adjustBalanceFn: func(id uuid.UUID, delta int64, currency enums.Currency, record *models.Transaction) (*models.Wallet, error) {
+			if id == missing {
+				return nil, repository.ErrWalletNotFound
+			}
+			return adjustWallet(models.Wallet{ID: a, Balance: 50})(id, delta, currency, record)
+		},
+	}
+	svc := services.New(mockRepo)
+
+	results, err := svc.Batch(context.Background(), []services.BatchItem{
+		{WalletID: a, OperationType: enums.WITHDRAW, Amount: 80},
+		{WalletID: missing, OperationType: enums.DEPOSIT, Amount: 10},
+		{WalletID: a, OperationType: enums.DEPOSIT, Amount: 10},
+		{WalletID: a, OperationType: enums.TRANSFER, Amount: 10},
+	}, false)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, services.ErrInsufficientFunds)
+	assert.ErrorIs(t, results[1].Err, services.ErrWalletNotFound)
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, int64(60), results[2].Wallet.Balance)
+	assert.ErrorIs(t, results[3].Err, services.ErrInvalidOperation)
+}
+
+func TestWalletService_Operation_Coalesced(t *testing.T) {
+	id := uuid.New()
+	wallet := &models.Wallet{ID: id, Balance: 0, Currency: enums.RUB}
+
+	var calls, applied int
+	mockRepo := &mockWalletRepo{
+		batchAtomicFn: func(steps []repository.BatchStep) error {
+			calls++
+			applied += len(steps)
+			return applySteps(map[uuid.UUID]*models.Wallet{id: wallet}, steps)
+		},
+	}
+	svc := services.New(mockRepo)
+	svc.EnableCoalescing(50*time.Millisecond, 1000)
+
+	const workers = 100
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.Operation(context.Background(), id, enums.DEPOSIT, 1, enums.RUB)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(workers), wallet.Balance)
+	assert.Equal(t, workers, applied)
+	assert.Less(t, calls, workers)
+}
+
+func TestWalletService_Operation_Coalesced_OwnResults(t *testing.T) {
+	id := uuid.New()
+	wallet := &models.Wallet{ID: id, Balance: 10, Currency: enums.RUB}
+
+	var groups [][]int64
+	mockRepo := &mockWalletRepo{
+		batchAtomicFn: func(steps []repository.BatchStep) error {
+			amounts := make([]int64, 0, len(steps))
+			for _, s := range steps {
+				amounts = append(amounts, s.Record.Amount)
+			}
+			groups = append(groups, amounts)
+			return applySteps(map[uuid.UUID]*models.Wallet{id: wallet}, steps)
+		},
+	}
+	svc := services.New(mockRepo)
+	svc.EnableCoalescing(200*time.Millisecond, 1000)
+
+	type result struct {
+		wallet *models.Wallet
+		err    error
+	}
+	ops := []struct {
+		op       enums.OperationType
+		amount   int64
+		currency enums.Currency
+	}{
+		{enums.DEPOSIT, 5, ""},
+		{enums.WITHDRAW, 100, ""},
+		{enums.DEPOSIT, 7, enums.USD},
+		{enums.WITHDRAW, 15, ""},
+	}
+
+	// The operations are queued a few milliseconds apart, well within one
+	// window, so they form a single group in this order.
+	results := make([]chan result, len(ops))
+	for i, o := range ops {
+		results[i] = make(chan result, 1)
+		go func() {
+			w, err := svc.Operation(context.Background(), id, o.op, o.amount, o.currency)
+			results[i] <- result{w, err}
+		}()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	got := make([]result, len(ops))
+	for i := range results {
+		got[i] = <-results[i]
+	}
+
+	assert.Equal(t, [][]int64{{5, 100, 7, 15}}, groups)
+	assert.NoError(t, got[0].err)
+	assert.Equal(t, int64(15), got[0].wallet.Balance)
+	assert.ErrorIs(t, got[1].err, services.ErrInsufficientFunds)
+	assert.ErrorIs(t, got[2].err, services.ErrCurrencyMismatch)
+	assert.NoError(t, got[3].err)
+	assert.Equal(t, int64(0), got[3].wallet.Balance)
+}
+
+func TestWalletService_Operation_Coalesced_GroupFails(t *testing.T) {
+	mockRepo := &mockWalletRepo{
+		batchAtomicFn: func(steps []repository.BatchStep) error {
+			return &repository.BatchStepError{Index: 0, Err: repository.ErrWalletNotFound}
+		},
+	}
+	svc := services.New(mockRepo)
+	svc.EnableCoalescing(time.Millisecond, 10)
+
+	_, err := svc.Operation(context.Background(), uuid.New(), enums.DEPOSIT, 1, "")
+	assert.ErrorIs(t, err, services.ErrWalletNotFound)
+}
+
+func TestWalletService_Reconcile(t *testing.T) {
+	id := uuid.New()
+	mismatch := repository.WalletMismatch{WalletID: id, Currency: enums.RUB, Balance: 100, LedgerBalance: 90}
+	mockRepo := &mockWalletRepo{
+		reconcileFn: func() (*repository.ReconciliationReport, error) {
+			return &repository.ReconciliationReport{Wallets: 3, Mismatches: []repository.WalletMismatch{mismatch}}, nil
+		},
+	}
+	svc := services.New(mockRepo)
+
+	report, err := svc.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, report.Clean())
+	assert.Equal(t, int64(3), report.Wallets)
+	assert.Equal(t, []repository.WalletMismatch{mismatch}, report.Mismatches)
+
+	mockRepo.reconcileFn = func() (*repository.ReconciliationReport, error) {
+		return &repository.ReconciliationReport{Wallets: 3}, nil
+	}
+	report, err = svc.Reconcile(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, report.Clean())
+}
+
+func TestWalletService_PublishEvents(t *testing.T) {
+	newEvent := func() models.OutboxEvent {
+		return models.OutboxEvent{ID: uuid.New(), Type: models.EventWalletCredited, WalletID: uuid.New()}
+	}
+	pending := []models.OutboxEvent{newEvent(), newEvent(), newEvent()}
+	mockRepo := &mockWalletRepo{pendingEvents: pending}
+	svc := services.New(mockRepo)
+	publisher := &events.MemoryPublisher{}
+
+	published, err := svc.PublishEvents(context.Background(), publisher, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published)
+	assert.Equal(t, pending[:2], publisher.Events())
+
+	publisher.FailWith(errors.New("broker down"))
+	published, err = svc.PublishEvents(context.Background(), publisher, 2)
+	assert.EqualError(t, err, "broker down")
+	assert.Zero(t, published)
+
+	publisher.FailWith(nil)
+	published, err = svc.PublishEvents(context.Background(), publisher, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, pending, publisher.Events())
 }
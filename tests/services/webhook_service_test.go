@@ -0,0 +1,421 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	enums "itk-academy-test/internal"
+	"itk-academy-test/internal/events"
+	"itk-academy-test/internal/models"
+	"itk-academy-test/internal/repository"
+	"itk-academy-test/internal/services"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockWebhookRepo keeps subscriptions and deliveries in memory. Claims
+// honour the due time but not the lease.
+type mockWebhookRepo struct {
+	mu         sync.Mutex
+	subs       map[uuid.UUID]models.WebhookSubscription
+	deliveries map[uuid.UUID]models.WebhookDelivery
+	leasedFor  time.Duration
+}
+
+func newMockWebhookRepo() *mockWebhookRepo {
+	return &mockWebhookRepo{
+		subs:       make(map[uuid.UUID]models.WebhookSubscription),
+		deliveries: make(map[uuid.UUID]models.WebhookDelivery),
+	}
+}
+
+func (m *mockWebhookRepo) CreateSubscription(_ context.Context, sub *models.WebhookSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs[sub.ID] = *sub
+	return nil
+}
+func (m *mockWebhookRepo) GetSubscription(_ context.Context, id uuid.UUID) (*models.WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub, ok := m.subs[id]
+	if !ok {
+		return nil, repository.ErrSubscriptionNotFound
+	}
+	return &sub, nil
+}
+func (m *mockWebhookRepo) ListSubscriptions(context.Context) ([]models.WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subs := make([]models.WebhookSubscription, 0, len(m.subs))
+	for _, sub := range m.subs {
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+func (m *mockWebhookRepo) UpdateSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	return m.CreateSubscription(ctx, sub)
+}
+func (m *mockWebhookRepo) DeleteSubscription(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.subs, id)
+	return nil
+}
+func (m *mockWebhookRepo) SubscriptionsFor(_ context.Context, eventType string) ([]models.WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var subs []models.WebhookSubscription
+	for _, sub := range m.subs {
+		if sub.Wants(eventType) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+func (m *mockWebhookRepo) EnqueueDeliveries(_ context.Context, deliveries []models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range deliveries {
+		duplicate := false
+		for _, existing := range m.deliveries {
+			duplicate = duplicate || existing.SubscriptionID == d.SubscriptionID && existing.EventID == d.EventID
+		}
+		if !duplicate {
+			m.deliveries[d.ID] = d
+		}
+	}
+	return nil
+}
+func (m *mockWebhookRepo) Deliveries(_ context.Context, filter repository.DeliveryFilter) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deliveries []models.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == filter.SubscriptionID && (filter.Status == "" || d.Status == filter.Status) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+func (m *mockWebhookRepo) GetDelivery(_ context.Context, subscriptionID, id uuid.UUID) (*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok || d.SubscriptionID != subscriptionID {
+		return nil, repository.ErrDeliveryNotFound
+	}
+	return &d, nil
+}
+func (m *mockWebhookRepo) ClaimDeliveries(_ context.Context, now, leaseUntil time.Time, limit int) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.leasedFor = leaseUntil.Sub(now)
+	var due []models.WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.Status == enums.DeliveryPending && !d.NextAttemptAt.After(now) && len(due) < limit {
+			due = append(due, d)
+		}
+	}
+	return due, nil
+}
+func (m *mockWebhookRepo) SaveDelivery(_ context.Context, delivery *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+// onlyDelivery returns the single delivery of the repository.
+func (m *mockWebhookRepo) onlyDelivery(t *testing.T) models.WebhookDelivery {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	require.Len(t, m.deliveries, 1)
+	for _, d := range m.deliveries {
+		return d
+	}
+	panic("unreachable")
+}
+
+// makeDue moves the next attempt of every pending delivery to the past, so
+// that the next dispatch does not wait out the backoff.
+func (m *mockWebhookRepo) makeDue() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, d := range m.deliveries {
+		d.NextAttemptAt = time.Now().Add(-time.Second)
+		m.deliveries[id] = d
+	}
+}
+
+// receivedDelivery is one request seen by the test receiver.
+type receivedDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+// newReceiver starts an httptest.Server that records every request and
+// answers the n-th with status(n).
+func newReceiver(t *testing.T, status func(n int) int) (*httptest.Server, func() []receivedDelivery) {
+	t.Helper()
+	var mu sync.Mutex
+	var received []receivedDelivery
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		received = append(received, receivedDelivery{header: r.Header.Clone(), body: body})
+		n := len(received)
+		mu.Unlock()
+
+		w.WriteHeader(status(n))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []receivedDelivery {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(received)
+	}
+}
+
+func newWebhookService(repo *mockWebhookRepo, retry services.WebhookRetryPolicy) *services.WebhookService {
+	return services.NewWebhookService(repo, &http.Client{Timeout: 5 * time.Second}, retry)
+}
+
+func TestWebhookService_CreateSubscription(t *testing.T) {
+	svc := newWebhookService(newMockWebhookRepo(), services.WebhookRetryPolicy{})
+
+	sub, err := svc.CreateSubscription(context.Background(), "http://example.com/hook",
+		[]string{models.EventWalletCredited, models.EventWalletDebited, models.EventWalletCredited})
+	assert.NoError(t, err)
+	assert.True(t, sub.Active)
+	assert.Len(t, sub.Secret, 64)
+	assert.Equal(t, models.EventTypes{models.EventWalletCredited, models.EventWalletDebited}, sub.EventTypes)
+
+	_, err = svc.CreateSubscription(context.Background(), "http://example.com/hook", []string{"wallet.exploded"})
+	assert.ErrorIs(t, err, services.ErrInvalidEventTypes)
+
+	_, err = svc.CreateSubscription(context.Background(), "http://example.com/hook", nil)
+	assert.ErrorIs(t, err, services.ErrInvalidEventTypes)
+}
+
+func TestWebhookService_DeliversSignedEvents(t *testing.T) {
+	repo := newMockWebhookRepo()
+	svc := newWebhookService(repo, services.WebhookRetryPolicy{})
+	server, received := newReceiver(t, func(int) int { return http.StatusNoContent })
+
+	sub, err := svc.CreateSubscription(context.Background(), server.URL, []string{models.EventWalletCredited})
+	require.NoError(t, err)
+	other, err := svc.CreateSubscription(context.Background(), server.URL, []string{models.EventWalletCreated})
+	require.NoError(t, err)
+
+	event := models.OutboxEvent{
+		ID:        uuid.New(),
+		Type:      models.EventWalletCredited,
+		WalletID:  uuid.New(),
+		Payload:   json.RawMessage(`{"amount":100}`),
+		CreatedAt: time.Now(),
+	}
+	require.NoError(t, svc.Publish(context.Background(), event))
+	require.NoError(t, svc.Publish(context.Background(), event), "a republished event is not queued again")
+
+	attempted, err := svc.DispatchDeliveries(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempted)
+
+	requests := received()
+	require.Len(t, requests, 1)
+	got := requests[0]
+
+	assert.Equal(t, event.ID.String(), got.header.Get("X-Event-ID"))
+	assert.Equal(t, models.EventWalletCredited, got.header.Get("X-Event-Type"))
+	timestamp, err := strconv.ParseInt(got.header.Get(events.WebhookTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.True(t, events.Verify(sub.Secret, timestamp, got.body, got.header.Get(events.WebhookSignatureHeader)))
+	assert.False(t, events.Verify(other.Secret, timestamp, got.body, got.header.Get(events.WebhookSignatureHeader)))
+
+	var body models.OutboxEvent
+	require.NoError(t, json.Unmarshal(got.body, &body))
+	assert.Equal(t, event.ID, body.ID)
+	assert.JSONEq(t, `{"amount":100}`, string(body.Payload))
+
+	delivery := repo.onlyDelivery(t)
+	assert.Equal(t, got.header.Get(events.WebhookIDHeader), delivery.ID.String())
+	assert.Equal(t, enums.DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusNoContent, *delivery.LastStatusCode)
+	assert.NotNil(t, delivery.DeliveredAt)
+}
+
+func TestWebhookService_RetriesWithBackoffThenDies(t *testing.T) {
+	repo := newMockWebhookRepo()
+	retry := services.WebhookRetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	svc := newWebhookService(repo, retry)
+	server, received := newReceiver(t, func(int) int { return http.StatusServiceUnavailable })
+
+	sub, err := svc.CreateSubscription(context.Background(), server.URL, []string{models.EventWalletCreated})
+	require.NoError(t, err)
+	require.NoError(t, svc.Publish(context.Background(), models.OutboxEvent{ID: uuid.New(), Type: models.EventWalletCreated}))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		before := time.Now()
+		_, err := svc.DispatchDeliveries(context.Background(), 10)
+		require.NoError(t, err)
+
+		delivery := repo.onlyDelivery(t)
+		assert.Equal(t, enums.DeliveryPending, delivery.Status)
+		assert.Equal(t, attempt, delivery.Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, *delivery.LastStatusCode)
+		assert.Contains(t, *delivery.LastError, "503")
+
+		backoff := retry.Backoff(attempt)
+		assert.Equal(t, time.Minute<<(attempt-1), backoff)
+		assert.WithinDuration(t, before.Add(backoff), delivery.NextAttemptAt, 5*time.Second)
+
+		attempted, err := svc.DispatchDeliveries(context.Background(), 10)
+		require.NoError(t, err)
+		assert.Zero(t, attempted, "not due before the backoff")
+		repo.makeDue()
+	}
+
+	_, err = svc.DispatchDeliveries(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Len(t, received(), 3)
+
+	dead, err := svc.Deliveries(context.Background(), sub.ID, enums.DeliveryDead, 0)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 3, dead[0].Attempts)
+
+	attempted, err := svc.DispatchDeliveries(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Zero(t, attempted, "dead deliveries are not attempted")
+
+	retried, err := svc.RetryDelivery(context.Background(), sub.ID, dead[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, enums.DeliveryPending, retried.Status)
+	assert.Zero(t, retried.Attempts)
+
+	_, err = svc.RetryDelivery(context.Background(), sub.ID, dead[0].ID)
+	assert.ErrorIs(t, err, services.ErrDeliveryNotDead)
+	_, err = svc.RetryDelivery(context.Background(), uuid.New(), dead[0].ID)
+	assert.ErrorIs(t, err, services.ErrDeliveryNotFound)
+}
+
+func TestWebhookService_RetryRecoversFromUnreachableReceiver(t *testing.T) {
+	repo := newMockWebhookRepo()
+	svc := newWebhookService(repo, services.WebhookRetryPolicy{})
+	server, received := newReceiver(t, func(n int) int {
+		if n == 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+
+	_, err := svc.CreateSubscription(context.Background(), server.URL, []string{models.EventWalletDebited})
+	require.NoError(t, err)
+	require.NoError(t, svc.Publish(context.Background(), models.OutboxEvent{ID: uuid.New(), Type: models.EventWalletDebited}))
+
+	_, err = svc.DispatchDeliveries(context.Background(), 10)
+	require.NoError(t, err)
+	repo.makeDue()
+	_, err = svc.DispatchDeliveries(context.Background(), 10)
+	require.NoError(t, err)
+
+	requests := received()
+	require.Len(t, requests, 2)
+	assert.Equal(t, requests[0].header.Get(events.WebhookIDHeader), requests[1].header.Get(events.WebhookIDHeader))
+	assert.Equal(t, requests[0].body, requests[1].body)
+
+	delivery := repo.onlyDelivery(t)
+	assert.Equal(t, enums.DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Nil(t, delivery.LastError)
+}
+
+func TestWebhookService_InactiveSubscriptionDeliveriesDie(t *testing.T) {
+	repo := newMockWebhookRepo()
+	svc := newWebhookService(repo, services.WebhookRetryPolicy{})
+	server, received := newReceiver(t, func(int) int { return http.StatusOK })
+
+	sub, err := svc.CreateSubscription(context.Background(), server.URL, []string{models.EventWalletCreated})
+	require.NoError(t, err)
+	require.NoError(t, svc.Publish(context.Background(), models.OutboxEvent{ID: uuid.New(), Type: models.EventWalletCreated}))
+
+	_, err = svc.UpdateSubscription(context.Background(), sub.ID, server.URL, []string{models.EventWalletCreated}, false)
+	require.NoError(t, err)
+
+	_, err = svc.DispatchDeliveries(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, received())
+
+	delivery := repo.onlyDelivery(t)
+	assert.Equal(t, enums.DeliveryDead, delivery.Status)
+	assert.Zero(t, delivery.Attempts)
+
+	require.NoError(t, svc.Publish(context.Background(), models.OutboxEvent{ID: uuid.New(), Type: models.EventWalletCreated}))
+	repo.onlyDelivery(t)
+}
+
+func TestWebhookService_LeaseOutlivesAttempt(t *testing.T) {
+	leasedFor := func(client *http.Client) time.Duration {
+		repo := newMockWebhookRepo()
+		_, err := services.NewWebhookService(repo, client, services.WebhookRetryPolicy{}).DispatchDeliveries(context.Background(), 10)
+		require.NoError(t, err)
+		return repo.leasedFor
+	}
+
+	assert.Equal(t, leasedFor(&http.Client{Timeout: events.DefaultWebhookTimeout}), leasedFor(&http.Client{}),
+		"a client without a timeout gets the default one, so that no attempt outlives its lease")
+}
+
+func TestWebhookService_RefusesPrivateDestinations(t *testing.T) {
+	repo := newMockWebhookRepo()
+	svc := services.NewWebhookService(repo, nil, services.WebhookRetryPolicy{})
+	server, received := newReceiver(t, func(int) int { return http.StatusOK })
+
+	_, err := svc.CreateSubscription(context.Background(), server.URL, []string{models.EventWalletCreated})
+	require.NoError(t, err)
+	require.NoError(t, svc.Publish(context.Background(), models.OutboxEvent{ID: uuid.New(), Type: models.EventWalletCreated}))
+
+	_, err = svc.DispatchDeliveries(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, received(), "the default client does not connect to loopback")
+
+	delivery := repo.onlyDelivery(t)
+	assert.Equal(t, enums.DeliveryPending, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	require.NotNil(t, delivery.LastError)
+	assert.Contains(t, *delivery.LastError, events.ErrPrivateDestination.Error())
+}
+
+func TestWebhookRetryPolicy_Backoff(t *testing.T) {
+	policy := services.WebhookRetryPolicy{MaxAttempts: 8, BaseDelay: 10 * time.Second, MaxDelay: time.Minute}
+
+	assert.Equal(t, 10*time.Second, policy.Backoff(1))
+	assert.Equal(t, 20*time.Second, policy.Backoff(2))
+	assert.Equal(t, 40*time.Second, policy.Backoff(3))
+	assert.Equal(t, time.Minute, policy.Backoff(4))
+	assert.Equal(t, time.Minute, policy.Backoff(100))
+}
+
+func TestWebhookService_Deliveries_UnknownSubscription(t *testing.T) {
+	svc := newWebhookService(newMockWebhookRepo(), services.WebhookRetryPolicy{})
+
+	_, err := svc.Deliveries(context.Background(), uuid.New(), "", 0)
+	assert.True(t, errors.Is(err, services.ErrSubscriptionNotFound))
+}
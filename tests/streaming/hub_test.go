@@ -0,0 +1,89 @@
+package streaming_test
+
+import (
+	"testing"
+
+	"itk-academy-test/internal/streaming"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_FansOutPerWallet(t *testing.T) {
+	hub := streaming.NewHub()
+	walletID, otherID := uuid.New(), uuid.New()
+
+	first := hub.Subscribe(walletID)
+	second := hub.Subscribe(walletID)
+	other := hub.Subscribe(otherID)
+	defer first.Close()
+	defer second.Close()
+	defer other.Close()
+
+	hub.Publish(streaming.WalletChange{WalletID: walletID, Balance: 100, Version: 1})
+
+	for _, sub := range []*streaming.Subscription{first, second} {
+		select {
+		case change := <-sub.C:
+			assert.Equal(t, int64(100), change.Balance)
+		default:
+			t.Fatal("change not delivered")
+		}
+	}
+	assert.Empty(t, other.C, "other wallets are not told")
+}
+
+func TestHub_SlowSubscriberKeepsLatestChanges(t *testing.T) {
+	hub := streaming.NewHub()
+	walletID := uuid.New()
+	sub := hub.Subscribe(walletID)
+	defer sub.Close()
+
+	for version := int64(1); version <= 100; version++ {
+		hub.Publish(streaming.WalletChange{WalletID: walletID, Version: version})
+	}
+
+	var last int64
+	for len(sub.C) > 0 {
+		last = (<-sub.C).Version
+	}
+	assert.Equal(t, int64(100), last)
+}
+
+func TestHub_CloseEndsSubscriptions(t *testing.T) {
+	hub := streaming.NewHub()
+	walletID := uuid.New()
+
+	sub := hub.Subscribe(walletID)
+	assert.Equal(t, 1, hub.Subscribers(walletID))
+	sub.Close()
+	sub.Close()
+	assert.Zero(t, hub.Subscribers(walletID))
+	_, ok := <-sub.C
+	assert.False(t, ok)
+
+	open := hub.Subscribe(walletID)
+	hub.Close()
+	_, ok = <-open.C
+	assert.False(t, ok)
+	open.Close()
+
+	late := hub.Subscribe(walletID)
+	_, ok = <-late.C
+	assert.False(t, ok, "subscriptions of a closed hub start closed")
+}
+
+func TestHub_Resync(t *testing.T) {
+	hub := streaming.NewHub()
+	walletID := uuid.New()
+	sub := hub.Subscribe(walletID)
+	defer sub.Close()
+
+	hub.Resync()
+
+	require.Len(t, sub.C, 1)
+	change := <-sub.C
+	assert.True(t, change.Resync)
+	assert.Equal(t, walletID, change.WalletID)
+}